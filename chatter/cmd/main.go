@@ -6,12 +6,19 @@ import (
 	"chatter/pkg/logger"
 	"chatter/pkg/telemetry"
 	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"go.uber.org/zap"
 )
 
+const telemetryShutdownTimeout = 5 * time.Second
+
 func main() {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	logger.InitLogger()
 
@@ -20,7 +27,11 @@ func main() {
 		logger.Logger.Fatal("Failed to initialize telemetry", zap.Error(err))
 	}
 	defer func() {
-		if err := shutdown(ctx); err != nil {
+		// ctx к этому моменту уже отменён сигналом
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), telemetryShutdownTimeout)
+		defer cancel()
+
+		if err := shutdown(shutdownCtx); err != nil {
 			logger.Logger.Error("Failed to shutdown telemetry", zap.Error(err))
 		}
 	}()
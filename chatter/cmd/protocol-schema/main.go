@@ -0,0 +1,18 @@
+// protocol-schema печатает JSON Schema протокола сигнализации.
+package main
+
+import (
+	"chatter/internal/signaling"
+	"fmt"
+	"os"
+)
+
+func main() {
+	schema, err := signaling.ProtocolSchema()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to build protocol schema:", err)
+		os.Exit(1)
+	}
+
+	fmt.Println(string(schema))
+}
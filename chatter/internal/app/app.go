@@ -2,6 +2,7 @@ package app
 
 import (
 	"chatter/internal/config"
+	"chatter/internal/domain"
 	"chatter/internal/handler"
 	"chatter/internal/infra"
 	"chatter/internal/repository"
@@ -12,12 +13,15 @@ import (
 	"chatter/pkg/redis"
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/otel"
 	"go.uber.org/zap"
 )
 
+const defaultShutdownTimeout = 15 * time.Second
+
 func Run(ctx context.Context, cfg *config.Config, logger *zap.Logger) {
 	pgpool, err := postgres.New(ctx, &cfg.Postgres)
 	if err != nil {
@@ -31,9 +35,6 @@ func Run(ctx context.Context, cfg *config.Config, logger *zap.Logger) {
 		logger.Fatal("Failed to connect to redis", zap.Error(err))
 	}
 
-	// заглушка, redis пока что не используется
-	_ = rdb
-
 	logger.Info("Connected to redis")
 
 	authStore := repository.NewAuthRepository(pgpool, logger)
@@ -42,8 +43,27 @@ func Run(ctx context.Context, cfg *config.Config, logger *zap.Logger) {
 	authService := usecase.NewAuthService(authStore, tokenStore, authManager, cfg.Auth.RefreshTTL, logger)
 	authHandler := handler.NewHandler(authService, logger)
 
-	registry := signaling.NewRegistry(logger)
-	signalingHandler := signaling.NewHandler(registry, authManager, logger)
+	roomStore := repository.NewRoomRepository(pgpool, logger)
+	roomService := usecase.NewRoomService(roomStore, authManager, cfg.Auth.InviteTTL, logger)
+	guestService := usecase.NewGuestService(authManager, cfg.Auth.GuestTTL, logger)
+	ticketStore := repository.NewTicketRepository(rdb, logger)
+	ticketService := usecase.NewTicketService(ticketStore, cfg.Auth.TicketTTL, logger)
+	messageStore := repository.NewMessageRepository(pgpool, logger)
+	messageService := usecase.NewMessageService(messageStore, logger)
+	moderationStore := repository.NewModerationRepository(pgpool, logger)
+	moderationService := usecase.NewModerationService(moderationStore, logger)
+
+	var broker signaling.Broker = signaling.NewMemoryBroker()
+	var redisBroker *signaling.RedisBroker
+	if cfg.Signaling.Broker == signaling.BrokerRedis {
+		redisBroker = signaling.NewRedisBroker(rdb, logger)
+		broker = redisBroker
+	}
+	logger.Info("Signaling broker selected", zap.String("broker", cfg.Signaling.Broker))
+
+	registry := signaling.NewRegistry(broker, messageService, moderationService, cfg.Signaling, logger)
+	signalingHandler := signaling.NewHandler(registry, roomService, guestService, ticketService, authService, messageService, cfg.Signaling, cfg.Server.WebSocketOrigins(), logger)
+	adminHandler := signaling.NewAdminHandler(registry, logger)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
@@ -58,8 +78,21 @@ func Run(ctx context.Context, cfg *config.Config, logger *zap.Logger) {
 	mux.Handle("GET /auth/sessions", middleware.RequireAuth(authManager, http.HandlerFunc(authHandler.Sessions)))
 
 	mux.Handle("POST /rooms", middleware.RequireAuth(authManager, http.HandlerFunc(signalingHandler.CreateRoom)))
+	mux.Handle("GET /rooms", middleware.RequireAuth(authManager, http.HandlerFunc(signalingHandler.ListRooms)))
+	mux.HandleFunc("GET /rooms/{id}", signalingHandler.GetRoom)
+	mux.Handle("GET /rooms/{id}/participants", middleware.RequireAuth(authManager, http.HandlerFunc(signalingHandler.ListParticipants)))
+	mux.Handle("GET /rooms/{id}/messages", middleware.RequireAuth(authManager, http.HandlerFunc(signalingHandler.ListMessages)))
+	mux.Handle("POST /rooms/{id}/invites", middleware.RequireAuth(authManager, http.HandlerFunc(signalingHandler.CreateInvite)))
+	mux.Handle("POST /ws-ticket", middleware.RequireAuth(authManager, http.HandlerFunc(signalingHandler.CreateTicket)))
+	mux.HandleFunc("POST /guest-ticket", signalingHandler.CreateGuestTicket)
+	mux.HandleFunc("GET /ws-protocol", signalingHandler.ProtocolSchema)
 	mux.HandleFunc("GET /ws/", signalingHandler.JoinRoom)
 
+	mux.Handle("GET /admin/rooms", middleware.RequireRole(authManager, domain.RoleAdmin, http.HandlerFunc(adminHandler.ListRooms)))
+	mux.Handle("POST /admin/rooms/{id}/close", middleware.RequireRole(authManager, domain.RoleAdmin, http.HandlerFunc(adminHandler.CloseRoom)))
+	mux.Handle("POST /admin/users/{id}/disconnect", middleware.RequireRole(authManager, domain.RoleAdmin, http.HandlerFunc(adminHandler.DisconnectUser)))
+	mux.Handle("POST /admin/announcements", middleware.RequireRole(authManager, domain.RoleAdmin, http.HandlerFunc(adminHandler.Announce)))
+
 	server := &http.Server{
 		Addr: cfg.Server.Addr,
 		Handler: middleware.WithTracing(
@@ -71,9 +104,41 @@ func Run(ctx context.Context, cfg *config.Config, logger *zap.Logger) {
 		),
 	}
 
-	logger.Info("Signaling server started", zap.String("addr", cfg.Server.Addr))
+	go func() {
+		logger.Info("Signaling server started", zap.String("addr", cfg.Server.Addr))
+
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Fatal("Signaling server failed", zap.Error(err))
+		}
+	}()
 
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		logger.Fatal("Signaling server failed", zap.Error(err))
+	<-ctx.Done()
+	logger.Info("Shutting down signaling server")
+
+	timeout := cfg.Server.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
 	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// вебсокеты захвачены у http.Server, и Shutdown их не ждёт: сначала разгоняем комнаты
+	if err := registry.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("Signaling rooms were not drained in time", zap.Error(err))
+	}
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Failed to shutdown http server", zap.Error(err))
+	}
+
+	if redisBroker != nil {
+		redisBroker.Close()
+	}
+	if err := rdb.Close(); err != nil {
+		logger.Error("Failed to close redis client", zap.Error(err))
+	}
+	pgpool.Close()
+
+	logger.Info("Signaling server stopped")
 }
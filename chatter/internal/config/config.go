@@ -1,6 +1,7 @@
 package config
 
 import (
+	"chatter/internal/signaling"
 	"chatter/pkg/postgres"
 	"chatter/pkg/redis"
 	"os"
@@ -10,20 +11,36 @@ import (
 )
 
 type Config struct {
-	Postgres postgres.PostgresConfig `yaml:"postgres" env-prefix:"POSTGRES_"`
-	Redis    redis.RedisConfig       `yaml:"redis" env-prefix:"REDIS_"`
-	Server   ServerConfig            `yaml:"server" env-prefix:"SERVER_"`
-	Auth     AuthConfig              `yaml:"auth" env-prefix:"AUTH_"`
+	Postgres  postgres.PostgresConfig `yaml:"postgres" env-prefix:"POSTGRES_"`
+	Redis     redis.RedisConfig       `yaml:"redis" env-prefix:"REDIS_"`
+	Server    ServerConfig            `yaml:"server" env-prefix:"SERVER_"`
+	Auth      AuthConfig              `yaml:"auth" env-prefix:"AUTH_"`
+	Signaling signaling.Config        `yaml:"signaling" env-prefix:"SIGNALING_"`
 }
 
 type ServerConfig struct {
 	Addr        string   `yaml:"addr" env:"ADDR"`
 	CorsOrigins []string `yaml:"cors_origins" env:"CORS_ORIGINS" env-separator:","`
+	// WSOrigins - откуда можно открывать WebSocket: "https://app.example.com",
+	// "*.example.com" и т.п.; пустой список - те же, что CorsOrigins
+	WSOrigins       []string      `yaml:"ws_origins" env:"WS_ORIGINS" env-separator:","`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+}
+
+func (c ServerConfig) WebSocketOrigins() []string {
+	if len(c.WSOrigins) > 0 {
+		return c.WSOrigins
+	}
+
+	return c.CorsOrigins
 }
 
 type AuthConfig struct {
 	AccessTTL  time.Duration `yaml:"access_ttl" env:"ACCESS_TTL"`
 	RefreshTTL time.Duration `yaml:"refresh_ttl" env:"REFRESH_TTL"`
+	InviteTTL  time.Duration `yaml:"invite_ttl" env:"INVITE_TTL"`
+	GuestTTL   time.Duration `yaml:"guest_ttl" env:"GUEST_TTL"`
+	TicketTTL  time.Duration `yaml:"ticket_ttl" env:"TICKET_TTL"`
 	Secret     string        `yaml:"secret" env:"SECRET"`
 }
 
@@ -46,13 +63,51 @@ func Load() *Config {
 func defaultConfig() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Addr:        ":8080",
-			CorsOrigins: []string{"http://localhost:5173"},
+			Addr:            ":8080",
+			CorsOrigins:     []string{"http://localhost:5173"},
+			ShutdownTimeout: 15 * time.Second,
 		},
 		Auth: AuthConfig{
 			Secret:     "a-string-secret-at-least-256-bits-long",
 			AccessTTL:  24 * time.Hour,
 			RefreshTTL: 1440 * time.Hour,
+			InviteTTL:  72 * time.Hour,
+			GuestTTL:   720 * time.Hour,
+			TicketTTL:  30 * time.Second,
+		},
+		Signaling: signaling.Config{
+			Broker:       signaling.BrokerMemory,
+			HistorySize:  50,
+			PingInterval: 20 * time.Second,
+			PongTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			IdleTimeout:  60 * time.Second,
+			ResumeGrace:  30 * time.Second,
+			ResumeBuffer: 32,
+			SendBuffer:   64,
+			RoomBuffer:   64,
+			Compression:  signaling.CompressionDisabled,
+
+			MaxMessageSize: 64 << 10,
+			RateLimits: signaling.RateLimits{
+				WebRTC:  signaling.RateLimit{Rate: 50, Burst: 200},
+				Chat:    signaling.RateLimit{Rate: 2, Burst: 10},
+				Profile: signaling.RateLimit{Rate: 1, Burst: 5},
+				Default: signaling.RateLimit{Rate: 10, Burst: 50},
+			},
+			UserRateLimits: signaling.RateLimits{
+				WebRTC:  signaling.RateLimit{Rate: 100, Burst: 400},
+				Chat:    signaling.RateLimit{Rate: 3, Burst: 15},
+				Profile: signaling.RateLimit{Rate: 2, Burst: 10},
+				Default: signaling.RateLimit{Rate: 20, Burst: 100},
+			},
+			MaxViolations:   20,
+			ViolationWindow: 10 * time.Second,
+
+			MaxRoomParticipants: 16,
+			MaxConnections:      10000,
+
+			ReconnectHint: 2 * time.Second,
 		},
 	}
 }
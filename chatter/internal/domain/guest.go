@@ -0,0 +1,17 @@
+package domain
+
+// Гостевые id выдаются из отдельного диапазона [2^52, 2^53): он не пересекается
+// с id из таблицы users и ещё точно представим числом в JavaScript.
+const (
+	GuestIDMin uint64 = 1 << 52
+	GuestIDMax uint64 = 1<<53 - 1
+)
+
+type Guest struct {
+	DisplayName string `json:"displayName"`
+	ID          uint64 `json:"id"`
+}
+
+func IsGuestID(id uint64) bool {
+	return id >= GuestIDMin && id <= GuestIDMax
+}
@@ -0,0 +1,12 @@
+package domain
+
+import "time"
+
+type Message struct {
+	CreatedAt   time.Time `json:"createdAt"`
+	RoomID      string    `json:"roomId"`
+	DisplayName string    `json:"displayName"`
+	Text        string    `json:"text"`
+	ID          uint64    `json:"id"`
+	UserID      uint64    `json:"userId"`
+}
@@ -0,0 +1,20 @@
+package domain
+
+import "time"
+
+const (
+	ModerationKick = "kick"
+	ModerationBan  = "ban"
+	ModerationMute = "mute"
+)
+
+type ModerationAction struct {
+	CreatedAt         time.Time `json:"createdAt"`
+	RoomID            string    `json:"roomId"`
+	Action            string    `json:"action"`
+	TargetFingerprint string    `json:"targetFingerprint,omitempty"`
+	Reason            string    `json:"reason,omitempty"`
+	ID                uint64    `json:"id"`
+	ActorID           uint64    `json:"actorId"`
+	TargetID          uint64    `json:"targetId"`
+}
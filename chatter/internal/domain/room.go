@@ -0,0 +1,34 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrRoomNotFound - комнаты с таким id или именем нет.
+var ErrRoomNotFound = errors.New("room not found")
+
+type Room struct {
+	CreatedAt    time.Time    `json:"createdAt"`
+	ID           string       `json:"id"`
+	Slug         string       `json:"slug,omitempty"`
+	Title        string       `json:"title"`
+	Settings     RoomSettings `json:"settings"`
+	PasscodeHash []byte       `json:"-"`
+	OwnerID      uint64       `json:"ownerId"`
+}
+
+const (
+	RoomAccessPublic   = "public"
+	RoomAccessPasscode = "passcode"
+	RoomAccessInvite   = "invite"
+)
+
+type RoomSettings struct {
+	Access          string   `json:"access,omitempty"`
+	Moderators      []uint64 `json:"moderators,omitempty"`
+	DisableChat     bool     `json:"disableChat,omitempty"`
+	DisableGuests   bool     `json:"disableGuests,omitempty"`
+	Lobby           bool     `json:"lobby,omitempty"`
+	MaxParticipants int      `json:"maxParticipants,omitempty"`
+}
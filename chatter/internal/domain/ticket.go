@@ -0,0 +1,16 @@
+package domain
+
+import "time"
+
+// WSTicket - одноразовый билет на вход в комнату по вебсокету. Выдаётся по
+// access-токену или гостевому токену, чтобы сам токен не попадал в URL.
+type WSTicket struct {
+	CreatedAt time.Time `json:"createdAt"`
+	RoomID    string    `json:"roomId"`
+	Username  string    `json:"username"`
+	UserID    uint64    `json:"userId"`
+
+	// у гостевого билета GuestToken - продлённый токен, который гость получит в welcome
+	Guest      bool   `json:"guest,omitempty"`
+	GuestToken string `json:"guestToken,omitempty"`
+}
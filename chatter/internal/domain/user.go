@@ -2,11 +2,17 @@ package domain
 
 import "time"
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	Username     string    `json:"username"`
 	Email        string    `json:"email"`
+	Role         string    `json:"role"`
 	PasswordHash []byte    `json:"-"`
 	ID           uint64    `json:"id"`
 }
@@ -14,8 +14,25 @@ type UserClaims struct {
 	jwt.RegisteredClaims
 	UserID   uint64 `json:"user_id"`
 	Username string `json:"username"`
+	Role     string `json:"role,omitempty"`
 }
 
+type InviteClaims struct {
+	jwt.RegisteredClaims
+	RoomID string `json:"room_id"`
+}
+
+type GuestClaims struct {
+	jwt.RegisteredClaims
+	GuestID     uint64 `json:"guest_id"`
+	DisplayName string `json:"display_name"`
+}
+
+const (
+	inviteAudience = "room-invite"
+	guestAudience  = "guest"
+)
+
 type JWTManager struct {
 	secret     []byte
 	accessTTL  time.Duration
@@ -30,7 +47,7 @@ func NewJWTManager(secret string, accessTTL time.Duration, refreshTTL time.Durat
 	}
 }
 
-func (m *JWTManager) GenerateAccessToken(userID uint64, username string) (string, error) {
+func (m *JWTManager) GenerateAccessToken(userID uint64, username, role string) (string, error) {
 	claims := UserClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
@@ -38,6 +55,7 @@ func (m *JWTManager) GenerateAccessToken(userID uint64, username string) (string
 		},
 		UserID:   userID,
 		Username: username,
+		Role:     role,
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -45,6 +63,25 @@ func (m *JWTManager) GenerateAccessToken(userID uint64, username string) (string
 }
 
 func (m *JWTManager) ParseAccessToken(tokenString string) (string, uint64, error) {
+	claims, err := m.parseUserClaims(tokenString)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return claims.Username, claims.UserID, nil
+}
+
+// ParseAccessClaims разбирает токен один раз и отдаёт всё, что нужно middleware.
+func (m *JWTManager) ParseAccessClaims(tokenString string) (string, uint64, string, error) {
+	claims, err := m.parseUserClaims(tokenString)
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	return claims.Username, claims.UserID, claims.Role, nil
+}
+
+func (m *JWTManager) parseUserClaims(tokenString string) (*UserClaims, error) {
 	parsed, err := jwt.ParseWithClaims(tokenString, &UserClaims{}, func(t *jwt.Token) (interface{}, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("unexpected signing method")
@@ -53,15 +90,84 @@ func (m *JWTManager) ParseAccessToken(tokenString string) (string, uint64, error
 		return m.secret, nil
 	})
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to parse token: %w", err)
+		return nil, fmt.Errorf("failed to parse token: %w", err)
 	}
 
 	claims, ok := parsed.Claims.(*UserClaims)
 	if !ok || !parsed.Valid {
-		return "", 0, errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
-	return claims.Username, claims.UserID, nil
+	return claims, nil
+}
+
+func (m *JWTManager) GenerateInviteToken(roomID string, ttl time.Duration) (string, error) {
+	claims := InviteClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{inviteAudience},
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(ttl)),
+		},
+		RoomID: roomID,
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(m.secret)
+}
+
+func (m *JWTManager) ParseInviteToken(tokenString string) (string, error) {
+	parsed, err := jwt.ParseWithClaims(tokenString, &InviteClaims{}, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+
+		return m.secret, nil
+	}, jwt.WithAudience(inviteAudience))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse invite: %w", err)
+	}
+
+	claims, ok := parsed.Claims.(*InviteClaims)
+	if !ok || !parsed.Valid || claims.RoomID == "" {
+		return "", errors.New("invalid invite")
+	}
+
+	return claims.RoomID, nil
+}
+
+func (m *JWTManager) GenerateGuestToken(guestID uint64, displayName string, ttl time.Duration) (string, error) {
+	claims := GuestClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{guestAudience},
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(ttl)),
+		},
+		GuestID:     guestID,
+		DisplayName: displayName,
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(m.secret)
+}
+
+func (m *JWTManager) ParseGuestToken(tokenString string) (uint64, string, error) {
+	parsed, err := jwt.ParseWithClaims(tokenString, &GuestClaims{}, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+
+		return m.secret, nil
+	}, jwt.WithAudience(guestAudience))
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to parse guest token: %w", err)
+	}
+
+	claims, ok := parsed.Claims.(*GuestClaims)
+	if !ok || !parsed.Valid || claims.GuestID == 0 {
+		return 0, "", errors.New("invalid guest token")
+	}
+
+	return claims.GuestID, claims.DisplayName, nil
 }
 
 func (m *JWTManager) GenerateRefreshToken() (string, error) {
@@ -25,7 +25,7 @@ func (r *AuthRepository) CreateUser(ctx context.Context, user *domain.User) (*do
 	query := `
 		INSERT INTO users (username, email, password_hash)
 		VALUES ($1, $2, $3)
-		RETURNING id, username, email
+		RETURNING id, username, email, role
 	`
 
 	row := r.pg.QueryRow(ctx, query, user.Username, user.Email, user.PasswordHash)
@@ -33,6 +33,7 @@ func (r *AuthRepository) CreateUser(ctx context.Context, user *domain.User) (*do
 		&user.ID,
 		&user.Username,
 		&user.Email,
+		&user.Role,
 	); err != nil {
 		r.logger.Error("Failed to create user", zap.Error(err))
 		return nil, fmt.Errorf("failed to create user: %w", err)
@@ -43,7 +44,7 @@ func (r *AuthRepository) CreateUser(ctx context.Context, user *domain.User) (*do
 
 func (r *AuthRepository) GetUserByUsername(ctx context.Context, username string) (*domain.User, bool) {
 	query := `
-		SELECT id, username, email, password_hash
+		SELECT id, username, email, role, password_hash
 		FROM users
 		WHERE username = $1
 	`
@@ -55,6 +56,7 @@ func (r *AuthRepository) GetUserByUsername(ctx context.Context, username string)
 		&user.ID,
 		&user.Username,
 		&user.Email,
+		&user.Role,
 		&user.PasswordHash,
 	); err != nil {
 		r.logger.Error("Failed to get user by username", zap.Error(err))
@@ -66,7 +68,7 @@ func (r *AuthRepository) GetUserByUsername(ctx context.Context, username string)
 
 func (r *AuthRepository) GetUserByID(ctx context.Context, id uint64) (*domain.User, bool) {
 	query := `
-		SELECT id, username, email, password_hash
+		SELECT id, username, email, role, password_hash
 		FROM users
 		WHERE id = $1
 	`
@@ -78,6 +80,7 @@ func (r *AuthRepository) GetUserByID(ctx context.Context, id uint64) (*domain.Us
 		&user.ID,
 		&user.Username,
 		&user.Email,
+		&user.Role,
 		&user.PasswordHash,
 	); err != nil {
 		r.logger.Error("Failed to get user by ID", zap.Error(err))
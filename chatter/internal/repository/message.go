@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"chatter/internal/domain"
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+type MessageRepository struct {
+	pg     *pgxpool.Pool
+	logger *zap.Logger
+}
+
+func NewMessageRepository(pg *pgxpool.Pool, logger *zap.Logger) *MessageRepository {
+	return &MessageRepository{
+		pg:     pg,
+		logger: logger,
+	}
+}
+
+func (r *MessageRepository) CreateMessage(ctx context.Context, message *domain.Message) (*domain.Message, error) {
+	query := `
+		INSERT INTO messages (room_id, user_id, display_name, text)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	row := r.pg.QueryRow(ctx, query, message.RoomID, message.UserID, message.DisplayName, message.Text)
+	if err := row.Scan(&message.ID, &message.CreatedAt); err != nil {
+		r.logger.Error("Failed to create message", zap.Error(err))
+		return nil, fmt.Errorf("failed to create message: %w", err)
+	}
+
+	return message, nil
+}
+
+// ListMessages возвращает сообщения комнаты с id меньше before, от новых к старым.
+// before = 0 означает "с самого последнего".
+func (r *MessageRepository) ListMessages(ctx context.Context, roomID string, before uint64, limit int) ([]domain.Message, error) {
+	query := `
+		SELECT id, room_id, user_id, display_name, text, created_at
+		FROM messages
+		WHERE room_id = $1 AND ($2 = 0 OR id < $2)
+		ORDER BY id DESC
+		LIMIT $3
+	`
+
+	rows, err := r.pg.Query(ctx, query, roomID, before, limit)
+	if err != nil {
+		r.logger.Error("Failed to list messages", zap.Error(err))
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []domain.Message
+	for rows.Next() {
+		var message domain.Message
+		if err := rows.Scan(
+			&message.ID,
+			&message.RoomID,
+			&message.UserID,
+			&message.DisplayName,
+			&message.Text,
+			&message.CreatedAt,
+		); err != nil {
+			r.logger.Error("Failed to scan message", zap.Error(err))
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Failed to read messages", zap.Error(err))
+		return nil, fmt.Errorf("failed to read messages: %w", err)
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"chatter/internal/domain"
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+type ModerationRepository struct {
+	pg     *pgxpool.Pool
+	logger *zap.Logger
+}
+
+func NewModerationRepository(pg *pgxpool.Pool, logger *zap.Logger) *ModerationRepository {
+	return &ModerationRepository{
+		pg:     pg,
+		logger: logger,
+	}
+}
+
+func (r *ModerationRepository) CreateAuditRecord(ctx context.Context, action *domain.ModerationAction) error {
+	query := `
+		INSERT INTO moderation_audit (room_id, actor_id, action, target_id, target_fingerprint, reason)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+
+	row := r.pg.QueryRow(ctx, query,
+		action.RoomID,
+		action.ActorID,
+		action.Action,
+		action.TargetID,
+		action.TargetFingerprint,
+		action.Reason,
+	)
+	if err := row.Scan(&action.ID, &action.CreatedAt); err != nil {
+		r.logger.Error("Failed to create moderation audit record", zap.Error(err))
+		return fmt.Errorf("failed to create moderation audit record: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"chatter/internal/domain"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+type RoomRepository struct {
+	pg     *pgxpool.Pool
+	logger *zap.Logger
+}
+
+func NewRoomRepository(pg *pgxpool.Pool, logger *zap.Logger) *RoomRepository {
+	return &RoomRepository{
+		pg:     pg,
+		logger: logger,
+	}
+}
+
+func (r *RoomRepository) CreateRoom(ctx context.Context, room *domain.Room) (*domain.Room, error) {
+	query := `
+		INSERT INTO rooms (id, owner_id, title, settings, passcode_hash, slug)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+		RETURNING created_at
+	`
+
+	row := r.pg.QueryRow(ctx, query, room.ID, room.OwnerID, room.Title, room.Settings, room.PasscodeHash, room.Slug)
+	if err := row.Scan(&room.CreatedAt); err != nil {
+		r.logger.Error("Failed to create room", zap.Error(err))
+		return nil, fmt.Errorf("failed to create room: %w", err)
+	}
+
+	return room, nil
+}
+
+func (r *RoomRepository) ListRoomsByOwner(ctx context.Context, ownerID uint64) ([]domain.Room, error) {
+	query := `
+		SELECT id, COALESCE(slug, ''), owner_id, title, settings, passcode_hash, created_at
+		FROM rooms
+		WHERE owner_id = $1
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.pg.Query(ctx, query, ownerID)
+	if err != nil {
+		r.logger.Error("Failed to list rooms", zap.Error(err))
+		return nil, fmt.Errorf("failed to list rooms: %w", err)
+	}
+	defer rows.Close()
+
+	var rooms []domain.Room
+	for rows.Next() {
+		var room domain.Room
+		if err := rows.Scan(
+			&room.ID,
+			&room.Slug,
+			&room.OwnerID,
+			&room.Title,
+			&room.Settings,
+			&room.PasscodeHash,
+			&room.CreatedAt,
+		); err != nil {
+			r.logger.Error("Failed to scan room", zap.Error(err))
+			return nil, fmt.Errorf("failed to scan room: %w", err)
+		}
+		rooms = append(rooms, room)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Failed to list rooms", zap.Error(err))
+		return nil, fmt.Errorf("failed to list rooms: %w", err)
+	}
+
+	return rooms, nil
+}
+
+func (r *RoomRepository) GetRoomByID(ctx context.Context, id string) (*domain.Room, error) {
+	query := `
+		SELECT id, COALESCE(slug, ''), owner_id, title, settings, passcode_hash, created_at
+		FROM rooms
+		WHERE id = $1
+	`
+
+	var room domain.Room
+
+	row := r.pg.QueryRow(ctx, query, id)
+	if err := row.Scan(
+		&room.ID,
+		&room.Slug,
+		&room.OwnerID,
+		&room.Title,
+		&room.Settings,
+		&room.PasscodeHash,
+		&room.CreatedAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, domain.ErrRoomNotFound
+		}
+		r.logger.Error("Failed to get room by ID", zap.Error(err))
+		return nil, fmt.Errorf("failed to get room by ID: %w", err)
+	}
+
+	return &room, nil
+}
+
+func (r *RoomRepository) GetRoomBySlug(ctx context.Context, slug string) (*domain.Room, error) {
+	query := `
+		SELECT id, COALESCE(slug, ''), owner_id, title, settings, passcode_hash, created_at
+		FROM rooms
+		WHERE slug = $1
+	`
+
+	var room domain.Room
+
+	row := r.pg.QueryRow(ctx, query, slug)
+	if err := row.Scan(
+		&room.ID,
+		&room.Slug,
+		&room.OwnerID,
+		&room.Title,
+		&room.Settings,
+		&room.PasscodeHash,
+		&room.CreatedAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, domain.ErrRoomNotFound
+		}
+		r.logger.Error("Failed to get room by slug", zap.Error(err))
+		return nil, fmt.Errorf("failed to get room by slug: %w", err)
+	}
+
+	return &room, nil
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"chatter/internal/domain"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+type TicketRepository struct {
+	rdb    *redis.Client
+	logger *zap.Logger
+}
+
+func NewTicketRepository(rdb *redis.Client, logger *zap.Logger) *TicketRepository {
+	return &TicketRepository{
+		rdb:    rdb,
+		logger: logger,
+	}
+}
+
+func (r *TicketRepository) CreateTicket(ctx context.Context, ticketHash string, ticket *domain.WSTicket, ttl time.Duration) error {
+	data, err := json.Marshal(ticket)
+	if err != nil {
+		return fmt.Errorf("failed to encode ticket: %w", err)
+	}
+
+	created, err := r.rdb.SetNX(ctx, ticketKey(ticketHash), data, ttl).Result()
+	if err != nil {
+		r.logger.Error("Failed to create ws ticket", zap.Error(err))
+		return fmt.Errorf("failed to create ticket: %w", err)
+	}
+	if !created {
+		return errors.New("ticket already exists")
+	}
+
+	return nil
+}
+
+// ConsumeTicket достаёт билет и сразу удаляет его: второй раз им не войти.
+func (r *TicketRepository) ConsumeTicket(ctx context.Context, ticketHash string) (*domain.WSTicket, bool) {
+	data, err := r.rdb.GetDel(ctx, ticketKey(ticketHash)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			r.logger.Error("Failed to consume ws ticket", zap.Error(err))
+		}
+		return nil, false
+	}
+
+	var ticket domain.WSTicket
+	if err := json.Unmarshal(data, &ticket); err != nil {
+		r.logger.Error("Failed to decode ws ticket", zap.Error(err))
+		return nil, false
+	}
+
+	return &ticket, true
+}
+
+func ticketKey(ticketHash string) string {
+	return "chatter:ws-ticket:" + ticketHash
+}
@@ -0,0 +1,193 @@
+package signaling
+
+import (
+	"chatter/pkg/middleware"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+const (
+	maxAdminReason        = 200
+	maxAnnouncementLength = 1000
+)
+
+type AdminHandler struct {
+	registry *Registry
+	logger   *zap.Logger
+}
+
+func NewAdminHandler(registry *Registry, logger *zap.Logger) *AdminHandler {
+	return &AdminHandler{
+		registry: registry,
+		logger:   logger,
+	}
+}
+
+type adminRoom struct {
+	ID              string    `json:"id"`
+	Title           string    `json:"title,omitempty"`
+	OwnerID         uint64    `json:"ownerId"`
+	Participants    int       `json:"participants"`
+	Waiting         int       `json:"waiting,omitempty"`
+	Occupancy       int       `json:"occupancy"`
+	MaxParticipants int       `json:"maxParticipants,omitempty"`
+	DroppedMessages uint64    `json:"droppedMessages"`
+	SlowConsumers   uint64    `json:"slowConsumers"`
+	CreatedAt       time.Time `json:"createdAt,omitzero"`
+}
+
+type adminRoomsResponse struct {
+	Node  string      `json:"node"`
+	Rooms []adminRoom `json:"rooms"`
+}
+
+type adminReasonRequest struct {
+	Reason string `json:"reason"`
+}
+
+type announcementRequest struct {
+	Text  string   `json:"text"`
+	Rooms []string `json:"rooms"`
+}
+
+type closeRoomResponse struct {
+	RoomID string `json:"roomId"`
+	Closed bool   `json:"closed"`
+}
+
+type disconnectResponse struct {
+	UserID      uint64 `json:"userId"`
+	Connections int    `json:"connections"`
+}
+
+type announcementResponse struct {
+	Rooms int `json:"rooms"`
+}
+
+// ListRooms показывает комнаты, открытые на этой ноде.
+func (h *AdminHandler) ListRooms(w http.ResponseWriter, r *http.Request) {
+	rooms := h.registry.Rooms()
+	resp := adminRoomsResponse{
+		Node:  h.registry.node,
+		Rooms: make([]adminRoom, 0, len(rooms)),
+	}
+
+	for _, room := range rooms {
+		snapshot, err := room.Snapshot(r.Context())
+		if err != nil {
+			continue
+		}
+
+		info := room.Info()
+		stats := room.Stats()
+		resp.Rooms = append(resp.Rooms, adminRoom{
+			ID:              info.ID,
+			Title:           info.Title,
+			OwnerID:         info.OwnerID,
+			Participants:    len(snapshot.Participants),
+			Waiting:         snapshot.Waiting,
+			Occupancy:       room.Occupancy(),
+			MaxParticipants: room.Capacity(),
+			DroppedMessages: stats.DroppedMessages,
+			SlowConsumers:   stats.SlowConsumers,
+			CreatedAt:       info.CreatedAt,
+		})
+	}
+
+	writeJSON(w, resp)
+}
+
+func (h *AdminHandler) CloseRoom(w http.ResponseWriter, r *http.Request) {
+	roomID := r.PathValue("id")
+
+	reason, ok := decodeReason(w, r)
+	if !ok {
+		return
+	}
+
+	closed := h.registry.CloseRoom(r.Context(), roomID, reason)
+
+	adminID, _ := middleware.UserIDFromContext(r.Context())
+	h.logger.Info("Admin closed room",
+		zap.Uint64("adminID", adminID),
+		zap.String("roomID", roomID),
+		zap.String("reason", reason),
+		zap.Bool("closedLocally", closed),
+	)
+
+	writeJSON(w, closeRoomResponse{RoomID: roomID, Closed: closed})
+}
+
+func (h *AdminHandler) DisconnectUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil || userID == 0 {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	reason, ok := decodeReason(w, r)
+	if !ok {
+		return
+	}
+
+	connections := h.registry.DisconnectUser(r.Context(), userID, reason)
+
+	adminID, _ := middleware.UserIDFromContext(r.Context())
+	h.logger.Info("Admin disconnected user",
+		zap.Uint64("adminID", adminID),
+		zap.Uint64("userID", userID),
+		zap.String("reason", reason),
+		zap.Int("connections", connections),
+	)
+
+	writeJSON(w, disconnectResponse{UserID: userID, Connections: connections})
+}
+
+func (h *AdminHandler) Announce(w http.ResponseWriter, r *http.Request) {
+	var req announcementRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	text := strings.TrimSpace(req.Text)
+	if text == "" || utf8.RuneCountInString(text) > maxAnnouncementLength {
+		http.Error(w, "announcement text is empty or too long", http.StatusBadRequest)
+		return
+	}
+
+	rooms := h.registry.Announce(r.Context(), req.Rooms, text)
+
+	adminID, _ := middleware.UserIDFromContext(r.Context())
+	h.logger.Info("Admin sent announcement",
+		zap.Uint64("adminID", adminID),
+		zap.Strings("rooms", req.Rooms),
+		zap.Int("deliveredRooms", rooms),
+	)
+
+	writeJSON(w, announcementResponse{Rooms: rooms})
+}
+
+func decodeReason(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req adminReasonRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return "", false
+	}
+
+	reason := strings.TrimSpace(req.Reason)
+	if utf8.RuneCountInString(reason) > maxAdminReason {
+		http.Error(w, "reason is too long", http.StatusBadRequest)
+		return "", false
+	}
+
+	return reason, true
+}
@@ -0,0 +1,84 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+const (
+	brokerTimeout = 2 * time.Second
+	storeTimeout  = 5 * time.Second
+)
+
+// brokerQueueSize - сколько записей в брокер комната держит в очереди, пока он не ответил.
+const brokerQueueSize = 256
+
+// rosterInterval - как часто комната сверяет устройства других нод с брокером.
+// Устройства упавшей ноды пропадают не позже чем через nodeHeartbeatTTL и этот интервал.
+const rosterInterval = 15 * time.Second
+
+const (
+	eventBroadcast  = "broadcast"
+	eventDirect     = "direct"
+	eventPresence   = "presence"
+	eventModeration = "moderation"
+	eventLobby      = "lobby"
+	eventControl    = "control"
+)
+
+type Event struct {
+	Node   string `json:"node"`
+	Kind   string `json:"kind"`
+	Sender uint64 `json:"sender,omitempty"`
+	To     uint64 `json:"to,omitempty"`
+	// ToClient - соединение-адресат личного сообщения
+	ToClient string          `json:"toClient,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+// Participant - одно соединение пользователя; у пользователя с нескольких
+// устройств записей столько же.
+type Participant struct {
+	ID          uint64 `json:"id"`
+	ClientID    string `json:"clientId"`
+	DisplayName string `json:"displayName,omitempty"`
+	Node        string `json:"node"`
+}
+
+// Broker разносит события комнаты между всеми нодами, которые её обслуживают.
+type Broker interface {
+	Publish(ctx context.Context, roomID string, event Event) error
+	Subscribe(ctx context.Context, roomID string) (<-chan Event, func(), error)
+	Join(ctx context.Context, roomID string, participant Participant) error
+	Leave(ctx context.Context, roomID, node, clientID string) error
+	Participants(ctx context.Context, roomID string) ([]Participant, error)
+}
+
+// MemoryBroker используется, когда сервер работает в одном экземпляре:
+// все участники комнаты живут в одном процессе, и делиться нечем.
+type MemoryBroker struct{}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, roomID string, event Event) error {
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(ctx context.Context, roomID string) (<-chan Event, func(), error) {
+	return nil, func() {}, nil
+}
+
+func (b *MemoryBroker) Join(ctx context.Context, roomID string, participant Participant) error {
+	return nil
+}
+
+func (b *MemoryBroker) Leave(ctx context.Context, roomID, node, clientID string) error {
+	return nil
+}
+
+func (b *MemoryBroker) Participants(ctx context.Context, roomID string) ([]Participant, error) {
+	return nil, nil
+}
@@ -2,30 +2,82 @@ package signaling
 
 import (
 	"context"
+	"encoding/json"
+	"sync/atomic"
+	"time"
 
 	"github.com/coder/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
+// flushTimeout ограничивает ожидание, пока writeLoop допишет очередь перед закрытием соединения.
+const flushTimeout = 2 * time.Second
+
 type Client struct {
 	userID   uint64
 	username string
-	conn     *websocket.Conn
-	room     *Room
-	send     chan []byte
+	// displayName - имя, которое сервер ставит в from; меняется только в readLoop
+	displayName string
+	conn        *websocket.Conn
+	room        *Room
+	out         *outbox
+	cfg         Config
+	limiter     *rateLimiter
+	logger      *zap.Logger
+	// protocol и codec - версия протокола и кодировка, о которых договорились при подключении
+	protocol int
+	codec    codec
+	// clientID - id соединения, по нему адресуется сигнализация; у одного
+	// пользователя с нескольких устройств их несколько
+	clientID string
+	// resumeToken выдаётся в welcome и позволяет занять своё место после короткого обрыва
+	resumeToken string
+	guest       bool
+	guestToken  string
+	fingerprint string
+	// session - контекст со спаном сессии, родитель спанов входящих сообщений
+	session context.Context
+	// joinedAt и detached меняются только в цикле комнаты
+	joinedAt time.Time
+	detached bool
+	// waiting - клиент ждёт в лобби, пока хост его не впустит
+	waiting atomic.Bool
+	// leaving выставляется, когда клиент сам закрыл соединение и ждать его не нужно
+	leaving bool
+	// время последнего входящего фрейма или pong, unix nano
+	lastSeen atomic.Int64
+	// закрывается, когда writeLoop завершился
+	flushed chan struct{}
 }
 
-func NewClient(userID uint64, username string, conn *websocket.Conn, room *Room) *Client {
+func NewClient(userID uint64, username string, conn *websocket.Conn, room *Room, cfg Config, limiter *rateLimiter, logger *zap.Logger) *Client {
 	return &Client{
-		userID:   userID,
-		username: username,
-		conn:     conn,
-		room:     room,
-		send:     make(chan []byte, 32),
+		userID:      userID,
+		clientID:    randomID(),
+		protocol:    ProtocolVersion,
+		codec:       jsonCodec,
+		username:    username,
+		displayName: username,
+		conn:        conn,
+		room:        room,
+		out:         newOutbox(cfg.SendBuffer),
+		cfg:         cfg,
+		limiter:     limiter,
+		logger:      logger,
+		flushed:     make(chan struct{}),
 	}
 }
 
 func (c *Client) Run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c.touch()
+
 	go c.writeLoop(ctx)
+	go c.keepalive(ctx)
 	c.readLoop(ctx)
 }
 
@@ -38,10 +90,45 @@ func (c *Client) readLoop(ctx context.Context) {
 	for {
 		_, data, err := c.conn.Read(ctx)
 		if err != nil {
+			trace.SpanFromContext(c.session).AddEvent("connection closed", trace.WithAttributes(
+				attribute.Int("websocket.close_code", int(websocket.CloseStatus(err))),
+			))
+			switch websocket.CloseStatus(err) {
+			case websocket.StatusNormalClosure, websocket.StatusGoingAway, websocket.StatusNoStatusRcvd:
+				c.leaving = true
+			}
 			return
 		}
 
-		c.room.HandleIncoming(c, data)
+		c.touch()
+
+		data, err = c.codec.unmarshal(data)
+		if err != nil {
+			c.room.sendError(c, "invalid_message", "message could not be decoded as "+c.codec.name)
+			continue
+		}
+
+		// конверт разбираем один раз: по типу считаются лимиты и выбирается обработчик
+		var base messageBase
+		malformed := json.Unmarshal(data, &base) != nil
+
+		if kind := limitKind(base.Type); !c.limiter.allow(kind) {
+			c.room.sendError(c, "rate_limited", "too many "+kind+" messages, slow down")
+			if c.limiter.violation() {
+				c.logger.Warn("Client keeps exceeding rate limits, disconnecting", zap.String("kind", kind))
+				c.leaving = true
+				c.disconnect(websocket.StatusPolicyViolation, "rate limit exceeded")
+				return
+			}
+			continue
+		}
+
+		if malformed {
+			c.room.rejectMalformed(c)
+			continue
+		}
+
+		c.room.HandleIncoming(c, base, data)
 	}
 }
 
@@ -49,18 +136,139 @@ func (c *Client) ID() uint64 {
 	return c.userID
 }
 
+func (c *Client) ClientID() string {
+	return c.clientID
+}
+
+func (c *Client) identity() senderInfo {
+	return senderInfo{
+		ClientID:    c.clientID,
+		UserID:      c.userID,
+		DisplayName: c.displayName,
+		Guest:       c.guest,
+	}
+}
+
 func (c *Client) writeLoop(ctx context.Context) {
+	defer close(c.flushed)
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case msg, ok := <-c.send:
+		case <-c.out.ready:
+		}
+
+		for {
+			item, ok := c.out.pop()
 			if !ok {
-				return
+				break
 			}
-			if err := c.conn.Write(ctx, websocket.MessageText, msg); err != nil {
+			if err := c.write(ctx, item.bytes()); err != nil {
+				c.logger.Info("Failed to write to client, dropping connection", zap.Error(err))
+				_ = c.conn.CloseNow()
 				return
 			}
+			messagesOut.WithLabelValues(metricType(item.kind)).Inc()
+		}
+
+		if c.out.isClosed() {
+			return
 		}
 	}
 }
+
+func (c *Client) disconnect(code websocket.StatusCode, reason string) {
+	_ = c.conn.Close(code, reason)
+}
+
+// closeAfterFlush закрывает соединение после того, как клиент получит уже поставленные
+// в очередь сообщения, например уведомление о кике. Очередь должна быть закрыта.
+func (c *Client) closeAfterFlush(code websocket.StatusCode, reason string) {
+	timer := time.NewTimer(flushTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-c.flushed:
+	case <-timer.C:
+	}
+
+	c.disconnect(code, reason)
+}
+
+func (c *Client) write(ctx context.Context, msg []byte) error {
+	if c.cfg.WriteTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.WriteTimeout)
+		defer cancel()
+	}
+
+	data, err := c.codec.marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return c.conn.Write(ctx, c.codec.messageType, data)
+}
+
+// keepalive пингует клиента и рвёт соединение, если он перестал отвечать:
+// иначе полуоткрытые TCP-соединения висят в комнате "призраками".
+func (c *Client) keepalive(ctx context.Context) {
+	interval := c.cfg.PingInterval
+	if interval <= 0 {
+		interval = c.cfg.IdleTimeout
+	}
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if c.cfg.IdleTimeout > 0 && time.Since(c.seen()) > c.cfg.IdleTimeout {
+			c.logger.Info("Client idle timeout exceeded, dropping connection")
+			_ = c.conn.CloseNow()
+			return
+		}
+
+		if c.cfg.PingInterval <= 0 {
+			continue
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, c.pongTimeout())
+		err := c.conn.Ping(pingCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil {
+				c.logger.Info("Client did not answer ping, dropping connection", zap.Error(err))
+				_ = c.conn.CloseNow()
+			}
+			return
+		}
+
+		c.touch()
+	}
+}
+
+func (c *Client) pongTimeout() time.Duration {
+	if c.cfg.PongTimeout > 0 {
+		return c.cfg.PongTimeout
+	}
+
+	return c.cfg.PingInterval
+}
+
+func (c *Client) touch() {
+	c.lastSeen.Store(time.Now().UnixNano())
+}
+
+func (c *Client) seen() time.Time {
+	return time.Unix(0, c.lastSeen.Load())
+}
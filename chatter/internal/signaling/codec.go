@@ -0,0 +1,60 @@
+package signaling
+
+import (
+	"chatter/pkg/msgpack"
+	"strings"
+
+	"github.com/coder/websocket"
+)
+
+// Подпротоколы WebSocket: кодировка сообщений и версия протокола сигнализации.
+// Без подпротокола клиент получает JSON.
+const (
+	SubprotocolJSON    = "chatter.v1.json"
+	SubprotocolMsgpack = "chatter.v1.msgpack"
+)
+
+// codec - кодировка сообщений на проводе для конкретного клиента. Комната работает
+// с JSON, бинарным клиентам сообщения перекодируются при записи и чтении, поэтому
+// JSON- и msgpack-клиенты сидят в одной комнате.
+type codec struct {
+	name        string
+	messageType websocket.MessageType
+	// encode и decode переводят из JSON в формат клиента и обратно; nil - без перекодирования
+	encode func([]byte) ([]byte, error)
+	decode func([]byte) ([]byte, error)
+}
+
+var (
+	jsonCodec    = codec{name: "json", messageType: websocket.MessageText}
+	msgpackCodec = codec{name: "msgpack", messageType: websocket.MessageBinary, encode: msgpack.FromJSON, decode: msgpack.ToJSON}
+)
+
+// subprotocols - что сервер поддерживает. Порядок здесь ничего не решает: формат
+// выбирается по списку, который клиент прислал в Sec-WebSocket-Protocol, а без
+// подпротокола клиент получает JSON.
+var subprotocols = []string{SubprotocolMsgpack, SubprotocolJSON}
+
+func codecFor(subprotocol string) codec {
+	if strings.EqualFold(subprotocol, SubprotocolMsgpack) {
+		return msgpackCodec
+	}
+
+	return jsonCodec
+}
+
+func (c codec) marshal(data []byte) ([]byte, error) {
+	if c.encode == nil {
+		return data, nil
+	}
+
+	return c.encode(data)
+}
+
+func (c codec) unmarshal(data []byte) ([]byte, error) {
+	if c.decode == nil {
+		return data, nil
+	}
+
+	return c.decode(data)
+}
@@ -0,0 +1,117 @@
+package signaling
+
+import (
+	"chatter/internal/domain"
+	"math/rand/v2"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+const (
+	defaultSendBuffer = 64
+	defaultRoomBuffer = 64
+)
+
+const (
+	BrokerMemory = "memory"
+	BrokerRedis  = "redis"
+)
+
+const (
+	CompressionDisabled          = "disabled"
+	CompressionContextTakeover   = "context_takeover"
+	CompressionNoContextTakeover = "no_context_takeover"
+)
+
+type Config struct {
+	Broker          string `yaml:"broker" env:"BROKER"`
+	AllowAdhocRooms bool   `yaml:"allow_adhoc_rooms" env:"ALLOW_ADHOC_ROOMS"`
+	HistorySize     int    `yaml:"history_size" env:"HISTORY_SIZE"`
+
+	PingInterval time.Duration `yaml:"ping_interval" env:"PING_INTERVAL"`
+	PongTimeout  time.Duration `yaml:"pong_timeout" env:"PONG_TIMEOUT"`
+	WriteTimeout time.Duration `yaml:"write_timeout" env:"WRITE_TIMEOUT"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout" env:"IDLE_TIMEOUT"`
+
+	ResumeGrace  time.Duration `yaml:"resume_grace" env:"RESUME_GRACE"`
+	ResumeBuffer int           `yaml:"resume_buffer" env:"RESUME_BUFFER"`
+
+	SendBuffer int `yaml:"send_buffer" env:"SEND_BUFFER"`
+	RoomBuffer int `yaml:"room_buffer" env:"ROOM_BUFFER"`
+
+	// Compression - режим permessage-deflate; CompressionThreshold - с какого размера
+	// сообщения сжимать, 0 - порог библиотеки по умолчанию.
+	Compression          string `yaml:"compression" env:"COMPRESSION"`
+	CompressionThreshold int    `yaml:"compression_threshold" env:"COMPRESSION_THRESHOLD"`
+
+	MaxMessageSize  int64         `yaml:"max_message_size" env:"MAX_MESSAGE_SIZE"`
+	RateLimits      RateLimits    `yaml:"rate_limits" env-prefix:"RATE_LIMIT_"`
+	UserRateLimits  RateLimits    `yaml:"user_rate_limits" env-prefix:"USER_RATE_LIMIT_"`
+	MaxViolations   int           `yaml:"max_violations" env:"MAX_VIOLATIONS"`
+	ViolationWindow time.Duration `yaml:"violation_window" env:"VIOLATION_WINDOW"`
+
+	// JoinAttempts - сколько раз с одного адреса можно ввести пароль или приглашение
+	// комнаты; считается до проверки, чтобы подбор не нагружал bcrypt.
+	JoinAttempts RateLimit `yaml:"join_attempts" env-prefix:"JOIN_ATTEMPT_"`
+	// GuestTickets - сколько гостевых билетов выдаётся одному адресу: каждый
+	// билет без токена заводит новую гостевую личность.
+	GuestTickets RateLimit `yaml:"guest_tickets" env-prefix:"GUEST_TICKET_"`
+
+	MaxRoomParticipants int `yaml:"max_room_participants" env:"MAX_ROOM_PARTICIPANTS"`
+	MaxLobbySize        int `yaml:"max_lobby_size" env:"MAX_LOBBY_SIZE"`
+	MaxConnections      int `yaml:"max_connections" env:"MAX_CONNECTIONS"`
+
+	// ReconnectHint - базовая задержка переподключения, которую сервер подсказывает
+	// клиентам при остановке; к ней добавляется случайный разброс.
+	ReconnectHint time.Duration `yaml:"reconnect_hint" env:"RECONNECT_HINT"`
+}
+
+// reconnectDelay - подсказка клиенту при остановке сервера: ReconnectHint плюс
+// случайный разброс того же размера.
+func (c Config) reconnectDelay() time.Duration {
+	if c.ReconnectHint <= 0 {
+		return 0
+	}
+
+	return c.ReconnectHint + rand.N(c.ReconnectHint)
+}
+
+func (c Config) compressionMode() websocket.CompressionMode {
+	switch c.Compression {
+	case CompressionContextTakeover:
+		return websocket.CompressionContextTakeover
+	case CompressionNoContextTakeover:
+		return websocket.CompressionNoContextTakeover
+	default:
+		return websocket.CompressionDisabled
+	}
+}
+
+func (c Config) roomBuffer() int {
+	if c.RoomBuffer > 0 {
+		return c.RoomBuffer
+	}
+
+	return defaultRoomBuffer
+}
+
+// roomCapacity - лимит участников комнаты: настройка комнаты, но не больше глобального.
+func (c Config) roomCapacity(settings domain.RoomSettings) int {
+	capacity := settings.MaxParticipants
+	if c.MaxRoomParticipants > 0 && (capacity <= 0 || capacity > c.MaxRoomParticipants) {
+		capacity = c.MaxRoomParticipants
+	}
+
+	return capacity
+}
+
+// lobbyCapacity - сколько клиентов может ждать в лобби комнаты на одной ноде:
+// MaxLobbySize, а если он не задан - столько же, сколько мест в комнате.
+func (c Config) lobbyCapacity(settings domain.RoomSettings) int {
+	if c.MaxLobbySize > 0 {
+		return c.MaxLobbySize
+	}
+
+	return c.roomCapacity(settings)
+}
@@ -1,99 +1,836 @@
 package signaling
 
 import (
+	"chatter/internal/domain"
+	"chatter/internal/usecase"
+	"chatter/pkg/middleware"
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coder/websocket"
+	"go.opentelemetry.io/otel/codes"
 	"go.uber.org/zap"
 )
 
-type TokenParser interface {
-	ParseAccessToken(tokenString string) (string, uint64, error)
+type TicketService interface {
+	IssueTicket(ctx context.Context, userID uint64, username, roomID string) (string, time.Time, error)
+	IssueGuestTicket(ctx context.Context, guest *domain.Guest, guestToken, roomID string) (string, time.Time, error)
+	RedeemTicket(ctx context.Context, ticket, roomID string) (*domain.WSTicket, error)
 }
 
+type SessionAuthenticator interface {
+	AuthenticateSession(ctx context.Context, refreshToken string) (*domain.User, error)
+}
+
+type GuestService interface {
+	Identify(token string) (*domain.Guest, string, error)
+}
+
+type RoomService interface {
+	CreateRoom(ctx context.Context, ownerID uint64, title, passcode, slug string, randomSlug bool, settings domain.RoomSettings) (*domain.Room, error)
+	GetRoom(ctx context.Context, roomID string) (*domain.Room, error)
+	ListOwnedRooms(ctx context.Context, ownerID uint64) ([]domain.Room, error)
+	CreateInvite(ctx context.Context, roomID string, userID uint64) (string, time.Time, error)
+	AuthorizeJoin(room *domain.Room, userID uint64, passcode, invite string) error
+}
+
+const refreshCookieName = "refresh_token"
+
+// Пароль и приглашение для REST-запросов к комнате идут в заголовках: query
+// оседает в логах прокси.
+const (
+	passcodeHeader = "X-Room-Passcode"
+	inviteHeader   = "X-Room-Invite"
+)
+
+var errTooManyAttempts = errors.New("too many attempts, try again later")
+
 type Handler struct {
-	registry    *Registry
-	logger      *zap.Logger
-	tokenParser TokenParser
+	registry *Registry
+	rooms    RoomService
+	guests   GuestService
+	tickets  TicketService
+	sessions SessionAuthenticator
+	messages MessageStore
+	logger   *zap.Logger
+	cfg      Config
+	// origins - шаблоны Origin, с которых разрешено подключаться к WebSocket
+	origins []string
+	// attempts - попытки ввести пароль или приглашение по адресу и комнате
+	attempts *keyLimiter
+	// guestTickets - гостевые билеты по адресу
+	guestTickets *keyLimiter
 }
 
-func NewHandler(registry *Registry, tokenParser TokenParser, logger *zap.Logger) *Handler {
+func NewHandler(registry *Registry, rooms RoomService, guests GuestService, tickets TicketService, sessions SessionAuthenticator, messages MessageStore, cfg Config, origins []string, logger *zap.Logger) *Handler {
 	return &Handler{
-		registry:    registry,
-		logger:      logger,
-		tokenParser: tokenParser,
+		registry:     registry,
+		rooms:        rooms,
+		guests:       guests,
+		tickets:      tickets,
+		sessions:     sessions,
+		messages:     messages,
+		logger:       logger,
+		cfg:          cfg,
+		origins:      origins,
+		attempts:     newKeyLimiter(cfg.JoinAttempts),
+		guestTickets: newKeyLimiter(cfg.GuestTickets),
 	}
 }
 
+type createRoomRequest struct {
+	Title    string              `json:"title"`
+	Passcode string              `json:"passcode"`
+	Settings domain.RoomSettings `json:"settings"`
+	// Slug - своё имя комнаты; RandomSlug - подобрать произносимое, если своего нет
+	Slug       string `json:"slug"`
+	RandomSlug bool   `json:"randomSlug"`
+}
+
 type createRoomResponse struct {
-	RoomID string `json:"roomId"`
-	WsURL  string `json:"wsUrl"`
+	RoomID    string              `json:"roomId"`
+	Slug      string              `json:"slug,omitempty"`
+	WsURL     string              `json:"wsUrl"`
+	Title     string              `json:"title"`
+	Settings  domain.RoomSettings `json:"settings"`
+	CreatedAt time.Time           `json:"createdAt"`
+}
+
+// ticketRequest - запрос билета. Пароль и приглашение проверяются при выдаче,
+// и билет пускает в комнату без них.
+type ticketRequest struct {
+	RoomID   string `json:"roomId"`
+	Passcode string `json:"passcode,omitempty"`
+	Invite   string `json:"invite,omitempty"`
+}
+
+type guestTicketRequest struct {
+	ticketRequest
+	// GuestToken - токен из прошлого welcome; без него гость получит новый id
+	GuestToken string `json:"guestToken,omitempty"`
+}
+
+type ticketResponse struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type inviteResponse struct {
+	Token     string    `json:"token"`
+	JoinURL   string    `json:"joinUrl"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type messagesResponse struct {
+	Messages   []chatMessage `json:"messages"`
+	NextCursor uint64        `json:"nextCursor,omitempty"`
+}
+
+type roomResponse struct {
+	ID              string              `json:"id"`
+	Slug            string              `json:"slug,omitempty"`
+	Title           string              `json:"title"`
+	OwnerID         uint64              `json:"ownerId"`
+	Settings        domain.RoomSettings `json:"settings"`
+	Participants    int                 `json:"participants"`
+	MaxParticipants int                 `json:"maxParticipants,omitempty"`
+	CreatedAt       time.Time           `json:"createdAt"`
+}
+
+// roomPromptResponse - всё, что видно о закрытой комнате без входа: этого
+// хватает, чтобы спросить пароль или приглашение.
+type roomPromptResponse struct {
+	ID     string `json:"id"`
+	Slug   string `json:"slug,omitempty"`
+	Access string `json:"access"`
+}
+
+type roomSummary struct {
+	ID              string    `json:"id"`
+	Slug            string    `json:"slug,omitempty"`
+	Title           string    `json:"title,omitempty"`
+	OwnerID         uint64    `json:"ownerId"`
+	Owned           bool      `json:"owned"`
+	Active          bool      `json:"active"`
+	Participants    int       `json:"participants"`
+	MaxParticipants int       `json:"maxParticipants,omitempty"`
+	CreatedAt       time.Time `json:"createdAt,omitzero"`
+}
+
+type roomsResponse struct {
+	Rooms []roomSummary `json:"rooms"`
+}
+
+type participantResponse struct {
+	ID          uint64           `json:"id"`
+	DisplayName string           `json:"displayName,omitempty"`
+	JoinedAt    time.Time        `json:"joinedAt,omitzero"`
+	Connections int              `json:"connections"`
+	Devices     []deviceResponse `json:"devices"`
+}
+
+type deviceResponse struct {
+	ClientID string    `json:"clientId"`
+	JoinedAt time.Time `json:"joinedAt,omitzero"`
+}
+
+type participantsResponse struct {
+	RoomID       string                `json:"roomId"`
+	Participants []participantResponse `json:"participants"`
+	Waiting      int                   `json:"waiting,omitempty"`
+}
+
+type rejectionResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+	Current int    `json:"current,omitempty"`
+	Max     int    `json:"max,omitempty"`
 }
 
 func (h *Handler) CreateRoom(w http.ResponseWriter, r *http.Request) {
-	roomID, err := generateRoomID()
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok || userID == 0 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req createRoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	room, err := h.rooms.CreateRoom(r.Context(), userID, req.Title, req.Passcode, req.Slug, req.RandomSlug, req.Settings)
 	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrInvalidSlug):
+			http.Error(w, "invalid room name: use 3-40 lowercase letters, digits and single hyphens", http.StatusBadRequest)
+			return
+		case errors.Is(err, usecase.ErrSlugTaken):
+			http.Error(w, "room name is already taken", http.StatusConflict)
+			return
+		case errors.Is(err, usecase.ErrInvalidTitle):
+			http.Error(w, "invalid room title", http.StatusBadRequest)
+			return
+		case errors.Is(err, usecase.ErrInvalidAccess):
+			http.Error(w, "invalid access policy", http.StatusBadRequest)
+			return
+		case errors.Is(err, usecase.ErrInvalidPasscode):
+			http.Error(w, "passcode is too short", http.StatusBadRequest)
+			return
+		case errors.Is(err, usecase.ErrInvalidCapacity):
+			http.Error(w, "invalid max participants", http.StatusBadRequest)
+			return
+		}
+		h.logger.Error("Failed to create room", zap.Uint64("userID", userID), zap.Error(err))
 		http.Error(w, "failed to create room", http.StatusInternalServerError)
 		return
 	}
 
+	h.logger.Info("Room created", zap.String("roomID", room.ID), zap.String("slug", room.Slug), zap.Uint64("userID", userID))
+
+	// имя комнаты проще продиктовать, поэтому в ссылке для входа отдаём его
+	path := room.ID
+	if room.Slug != "" {
+		path = room.Slug
+	}
+
 	resp := createRoomResponse{
-		RoomID: roomID,
-		WsURL:  websocketURL(r, roomID),
+		RoomID:    room.ID,
+		Slug:      room.Slug,
+		WsURL:     websocketURL(r, path),
+		Title:     room.Title,
+		Settings:  room.Settings,
+		CreatedAt: room.CreatedAt,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	writeJSON(w, resp)
+}
+
+func (h *Handler) GetRoom(w http.ResponseWriter, r *http.Request) {
+	room, err := h.rooms.GetRoom(r.Context(), r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, usecase.ErrRoomNotFound) {
+			http.Error(w, "room not found", http.StatusNotFound)
+			return
+		}
+		h.logger.Error("Failed to get room", zap.Error(err))
+		http.Error(w, "failed to get room", http.StatusInternalServerError)
+		return
+	}
+
+	// маршрут открытый, поэтому о комнате под паролем или приглашением не
+	// раскрываем ни название, ни владельца, ни число участников
+	switch room.Settings.Access {
+	case domain.RoomAccessPasscode, domain.RoomAccessInvite:
+		writeJSON(w, roomPromptResponse{
+			ID:     room.ID,
+			Slug:   room.Slug,
+			Access: room.Settings.Access,
+		})
+		return
+	}
+
+	writeJSON(w, roomResponse{
+		ID:              room.ID,
+		Slug:            room.Slug,
+		Title:           room.Title,
+		OwnerID:         room.OwnerID,
+		Settings:        room.Settings,
+		Participants:    h.registry.Occupancy(r.Context(), room.ID),
+		MaxParticipants: h.registry.Capacity(room),
+		CreatedAt:       room.CreatedAt,
+	})
+}
+
+// ListRooms отдаёт комнаты, которыми владеет пользователь, и открытые на этой ноде
+// комнаты, в которых он сейчас находится.
+func (h *Handler) ListRooms(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok || userID == 0 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	owned, err := h.rooms.ListOwnedRooms(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("Failed to list rooms", zap.Uint64("userID", userID), zap.Error(err))
+		http.Error(w, "failed to list rooms", http.StatusInternalServerError)
 		return
 	}
+
+	resp := roomsResponse{Rooms: make([]roomSummary, 0, len(owned))}
+	seen := make(map[string]struct{}, len(owned))
+	for i := range owned {
+		room := &owned[i]
+		_, active := h.registry.Get(room.ID)
+		seen[room.ID] = struct{}{}
+		resp.Rooms = append(resp.Rooms, roomSummary{
+			ID:              room.ID,
+			Slug:            room.Slug,
+			Title:           room.Title,
+			OwnerID:         room.OwnerID,
+			Owned:           true,
+			Active:          active,
+			Participants:    h.registry.Occupancy(r.Context(), room.ID),
+			MaxParticipants: h.registry.Capacity(room),
+			CreatedAt:       room.CreatedAt,
+		})
+	}
+
+	for _, room := range h.registry.Rooms() {
+		if _, ok := seen[room.ID()]; ok {
+			continue
+		}
+
+		snapshot, err := room.Snapshot(r.Context())
+		if err != nil || !snapshot.Has(userID) {
+			continue
+		}
+
+		info := room.Info()
+		resp.Rooms = append(resp.Rooms, roomSummary{
+			ID:              info.ID,
+			Slug:            info.Slug,
+			Title:           info.Title,
+			OwnerID:         info.OwnerID,
+			Active:          true,
+			Participants:    room.Occupancy(),
+			MaxParticipants: room.Capacity(),
+			CreatedAt:       info.CreatedAt,
+		})
+	}
+
+	writeJSON(w, resp)
+}
+
+func (h *Handler) ListParticipants(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok || userID == 0 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID := r.PathValue("id")
+
+	room, err := h.rooms.GetRoom(r.Context(), roomID)
+	if err != nil {
+		if !errors.Is(err, usecase.ErrRoomNotFound) {
+			h.logger.Error("Failed to get room", zap.String("roomID", roomID), zap.Error(err))
+			http.Error(w, "failed to get room", http.StatusInternalServerError)
+			return
+		}
+		active, ok := h.registry.Get(roomID)
+		if !ok {
+			http.Error(w, "room not found", http.StatusNotFound)
+			return
+		}
+		info := active.Info()
+		room = &info
+	}
+	roomID = room.ID
+
+	if err := h.authorizeJoin(r, room, userID, r.Header.Get(passcodeHeader), r.Header.Get(inviteHeader)); err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	snapshot, err := h.registry.Snapshot(r.Context(), roomID)
+	if err != nil {
+		h.logger.Error("Failed to get room snapshot", zap.String("roomID", roomID), zap.Error(err))
+		http.Error(w, "failed to get participants", http.StatusInternalServerError)
+		return
+	}
+
+	resp := participantsResponse{
+		RoomID:       roomID,
+		Participants: make([]participantResponse, 0, len(snapshot.Participants)),
+		Waiting:      snapshot.Waiting,
+	}
+	for _, p := range snapshot.Participants {
+		participant := participantResponse{
+			ID:          p.ID,
+			DisplayName: p.DisplayName,
+			JoinedAt:    p.JoinedAt,
+			Connections: p.Connections,
+			Devices:     make([]deviceResponse, 0, len(p.Devices)),
+		}
+		for _, device := range p.Devices {
+			participant.Devices = append(participant.Devices, deviceResponse{
+				ClientID: device.ClientID,
+				JoinedAt: device.JoinedAt,
+			})
+		}
+		resp.Participants = append(resp.Participants, participant)
+	}
+
+	writeJSON(w, resp)
+}
+
+func (h *Handler) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok || userID == 0 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID := r.PathValue("id")
+	token, expiresAt, err := h.rooms.CreateInvite(r.Context(), roomID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrRoomNotFound):
+			http.Error(w, "room not found", http.StatusNotFound)
+		case errors.Is(err, usecase.ErrForbidden):
+			http.Error(w, "only the room owner can create invites", http.StatusForbidden)
+		default:
+			h.logger.Error("Failed to create invite", zap.String("roomID", roomID), zap.Error(err))
+			http.Error(w, "failed to create invite", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSON(w, inviteResponse{
+		Token:     token,
+		JoinURL:   websocketURL(r, roomID),
+		ExpiresAt: expiresAt,
+	})
+}
+
+func (h *Handler) ListMessages(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok || userID == 0 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID := r.PathValue("id")
+	query := r.URL.Query()
+
+	room, err := h.rooms.GetRoom(r.Context(), roomID)
+	if err != nil {
+		if !errors.Is(err, usecase.ErrRoomNotFound) {
+			h.logger.Error("Failed to get room", zap.String("roomID", roomID), zap.Error(err))
+			http.Error(w, "failed to get room", http.StatusInternalServerError)
+			return
+		}
+		if !h.cfg.AllowAdhocRooms {
+			http.Error(w, "room not found", http.StatusNotFound)
+			return
+		}
+		room = &domain.Room{ID: roomID}
+	}
+	roomID = room.ID
+
+	if err := h.authorizeJoin(r, room, userID, r.Header.Get(passcodeHeader), r.Header.Get(inviteHeader)); err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	var before uint64
+	if cursor := query.Get("before"); cursor != "" {
+		before, err = strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	messages, next, err := h.messages.History(r.Context(), roomID, before, limit)
+	if err != nil {
+		h.logger.Error("Failed to list messages", zap.String("roomID", roomID), zap.Error(err))
+		http.Error(w, "failed to list messages", http.StatusInternalServerError)
+		return
+	}
+
+	resp := messagesResponse{
+		Messages:   make([]chatMessage, 0, len(messages)),
+		NextCursor: next,
+	}
+	for i := range messages {
+		resp.Messages = append(resp.Messages, chatFromDomain(&messages[i]))
+	}
+
+	writeJSON(w, resp)
+}
+
+// CreateTicket выдаёт одноразовый билет для подключения к комнате по вебсокету.
+func (h *Handler) CreateTicket(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok || userID == 0 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	username, _ := middleware.UserFromContext(r.Context())
+
+	var req ticketRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RoomID == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	room, ok := h.ticketRoom(w, r, req.RoomID)
+	if !ok {
+		return
+	}
+	if err := h.authorizeJoin(r, room, userID, req.Passcode, req.Invite); err != nil {
+		h.logger.Info("Ticket refused", zap.String("roomID", room.ID), zap.Uint64("userID", userID), zap.Error(err))
+		writeAuthError(w, err)
+		return
+	}
+
+	ticket, expiresAt, err := h.tickets.IssueTicket(r.Context(), userID, username, room.ID)
+	if err != nil {
+		http.Error(w, "failed to issue ticket", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, ticketResponse{
+		Ticket:    ticket,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// CreateGuestTicket выдаёт билет гостю. Гость получает id из отдельного диапазона
+// и подписанный токен, с которым вернётся под тем же id после переподключения.
+func (h *Handler) CreateGuestTicket(w http.ResponseWriter, r *http.Request) {
+	if !h.guestTickets.allow(clientIP(r), time.Now()) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	var req guestTicketRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RoomID == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	room, ok := h.ticketRoom(w, r, req.RoomID)
+	if !ok {
+		return
+	}
+
+	identity, signed, err := h.guests.Identify(req.GuestToken)
+	if err != nil {
+		h.logger.Error("Failed to identify guest", zap.String("roomID", room.ID), zap.Error(err))
+		http.Error(w, "failed to issue ticket", http.StatusInternalServerError)
+		return
+	}
+	if err := h.authorizeJoin(r, room, identity.ID, req.Passcode, req.Invite); err != nil {
+		h.logger.Info("Guest ticket refused", zap.String("roomID", room.ID), zap.Uint64("userID", identity.ID), zap.Error(err))
+		writeAuthError(w, err)
+		return
+	}
+
+	ticket, expiresAt, err := h.tickets.IssueGuestTicket(r.Context(), identity, signed, room.ID)
+	if err != nil {
+		http.Error(w, "failed to issue ticket", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, ticketResponse{
+		Ticket:    ticket,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// ticketRoom находит комнату для билета. Билет привязываем к id, даже если
+// клиент знает комнату по имени.
+func (h *Handler) ticketRoom(w http.ResponseWriter, r *http.Request, roomID string) (*domain.Room, bool) {
+	room, err := h.rooms.GetRoom(r.Context(), roomID)
+	if err != nil {
+		if !errors.Is(err, usecase.ErrRoomNotFound) {
+			h.logger.Error("Failed to get room", zap.String("roomID", roomID), zap.Error(err))
+			http.Error(w, "failed to get room", http.StatusInternalServerError)
+			return nil, false
+		}
+		if !h.cfg.AllowAdhocRooms {
+			http.Error(w, "room not found", http.StatusNotFound)
+			return nil, false
+		}
+		return &domain.Room{ID: roomID}, true
+	}
+
+	return room, true
+}
+
+// authorizeJoin проверяет доступ к комнате. Попытки с паролем или приглашением
+// считаются по адресу и комнате до bcrypt: подбор упирается в лимит, а не в CPU.
+func (h *Handler) authorizeJoin(r *http.Request, room *domain.Room, userID uint64, passcode, invite string) error {
+	if passcode != "" || invite != "" {
+		if !h.attempts.allow(clientIP(r)+"|"+room.ID, time.Now()) {
+			return errTooManyAttempts
+		}
+	}
+
+	return h.rooms.AuthorizeJoin(room, userID, passcode, invite)
+}
+
+func writeAuthError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errTooManyAttempts):
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, usecase.ErrPasscodeRequired), errors.Is(err, usecase.ErrInviteRequired):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	default:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	}
+}
+
+// ProtocolSchema отдаёт JSON Schema сообщений сигнализации для авторов клиентов.
+func (h *Handler) ProtocolSchema(w http.ResponseWriter, r *http.Request) {
+	schema, err := ProtocolSchema()
+	if err != nil {
+		h.logger.Error("Failed to build protocol schema", zap.Error(err))
+		http.Error(w, "failed to build protocol schema", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	_, _ = w.Write(schema)
 }
 
 func (h *Handler) JoinRoom(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	roomID := strings.TrimPrefix(r.URL.Path, "/ws/")
-	if roomID == "" || strings.Contains(roomID, "/") {
+	if roomID == "" || roomID == controlChannel || strings.Contains(roomID, "/") {
 		http.Error(w, "invalid room id", http.StatusBadRequest)
 		return
 	}
 
-	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		OriginPatterns: []string{"*"},
-	})
-	if err != nil {
+	// refresh-кука уходит и с чужих страниц, поэтому Origin проверяем до того,
+	// как по ней кого-то аутентифицировать
+	if !originAllowed(r, h.origins) {
+		rejectedOrigins.Inc()
+		h.logger.Warn("Join rejected, origin not allowed", zap.String("roomID", roomID), zap.String("origin", r.Header.Get("Origin")))
+		http.Error(w, "origin not allowed", http.StatusForbidden)
 		return
 	}
 
 	var (
 		clientName   string
 		clientUserID uint64
+		guestToken   string
 	)
 
-	token := r.URL.Query().Get("token")
-	if token != "" {
-		if username, userID, err := h.tokenParser.ParseAccessToken(token); err == nil && username != "" && userID != 0 {
-			clientName = username
-			clientUserID = userID
+	query := r.URL.Query()
+	protocol, err := negotiateProtocol(query.Get("protocol"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// в пути может стоять и id, и имя комнаты; дальше везде работаем с id
+	info, err := h.rooms.GetRoom(r.Context(), roomID)
+	adhoc := false
+	if err != nil {
+		if !errors.Is(err, usecase.ErrRoomNotFound) {
+			h.logger.Error("Failed to get room", zap.String("roomID", roomID), zap.Error(err))
+			http.Error(w, "failed to get room", http.StatusInternalServerError)
+			return
+		}
+		if !h.cfg.AllowAdhocRooms {
+			http.Error(w, "room not found", http.StatusNotFound)
+			return
+		}
+		info = &domain.Room{ID: roomID}
+		adhoc = true
+	}
+	roomID = info.ID
+
+	// access- и гостевой токены в URL оседают в логах прокси и в трейсах, поэтому
+	// здесь принимаем только одноразовый билет или refresh-куку
+	guest := false
+	ticketed := false
+	if ticket := query.Get("ticket"); ticket != "" {
+		record, err := h.tickets.RedeemTicket(r.Context(), ticket, roomID)
+		if err != nil {
+			h.logger.Info("Join rejected, invalid ticket", zap.String("roomID", roomID), zap.Error(err))
+			http.Error(w, "invalid ticket", http.StatusUnauthorized)
+			return
+		}
+		clientName = record.Username
+		clientUserID = record.UserID
+		guest = record.Guest
+		guestToken = record.GuestToken
+		ticketed = true
+	} else if cookie, err := r.Cookie(refreshCookieName); err == nil && cookie.Value != "" && sameOrigin(r) {
+		// куку браузер подставит и в запрос с чужой страницы, так что по ней
+		// пускаем только со своего origin; остальным нужен билет
+		if user, err := h.sessions.AuthenticateSession(r.Context(), cookie.Value); err == nil {
+			clientName = user.Username
+			clientUserID = user.ID
+		}
+	}
+
+	// без билета и сессии - новый гость; вернуться под прежним id можно
+	// только через гостевой билет
+	if clientUserID == 0 {
+		guest = true
+		identity, signed, err := h.guests.Identify("")
+		if err != nil {
+			h.logger.Error("Failed to identify guest", zap.String("roomID", roomID), zap.Error(err))
+			http.Error(w, "failed to join room", http.StatusInternalServerError)
+			return
+		}
+		clientName = identity.DisplayName
+		clientUserID = identity.ID
+		guestToken = signed
+	}
+
+	if adhoc && !guest {
+		info.OwnerID = clientUserID
+	}
+
+	// пароль и приглашение проверены при выдаче билета; без билета пускаем
+	// только туда, где они не нужны
+	if !ticketed {
+		if err := h.rooms.AuthorizeJoin(info, clientUserID, "", ""); err != nil {
+			writeAuthError(w, err)
+			h.logger.Info("Join rejected", zap.String("roomID", roomID), zap.Uint64("userID", clientUserID), zap.Error(err))
+			return
 		}
 	}
 
-	if clientName == "" {
-		clientName = randomID()
+	fingerprint := clientFingerprint(r)
+	if room, ok := h.registry.Get(roomID); ok && room.IsBanned(clientUserID, guest, fingerprint) {
+		h.logger.Info("Join rejected, client is banned", zap.String("roomID", roomID), zap.Uint64("userID", clientUserID))
+		http.Error(w, "banned from room", http.StatusForbidden)
+		return
+	}
+
+	release, err := h.registry.Admit(r.Context(), info)
+	if err != nil {
+		h.logger.Info("Join rejected", zap.String("roomID", roomID), zap.Uint64("userID", clientUserID), zap.Error(err))
+		writeRejection(w, err)
+		return
+	}
+	defer release()
+
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		OriginPatterns:       h.origins,
+		Subprotocols:         subprotocols,
+		CompressionMode:      h.cfg.compressionMode(),
+		CompressionThreshold: h.cfg.CompressionThreshold,
+	})
+	if err != nil {
+		return
+	}
+	if h.cfg.MaxMessageSize > 0 {
+		conn.SetReadLimit(h.cfg.MaxMessageSize)
 	}
 
-	room := h.registry.GetOrCreate(r.Context(), roomID, clientUserID)
+	room := h.registry.GetOrCreate(r.Context(), info)
 	if room == nil {
-		h.logger.Error("Failed to get or create room", zap.String("roomID", roomID))
+		_ = conn.Close(websocket.StatusTryAgainLater, "server shutting down")
+		return
+	}
+
+	client := NewClient(clientUserID, clientName, conn, room, h.cfg, h.registry.rateLimiter(clientUserID), h.logger.With(
+		zap.String("roomID", room.ID()),
+		zap.Uint64("userID", clientUserID),
+	))
+	client.protocol = protocol
+	client.codec = codecFor(conn.Subprotocol())
+	client.resumeToken = query.Get("resume")
+	client.guest = guest
+	client.guestToken = guestToken
+	client.fingerprint = fingerprint
+
+	ctx, span := startSession(r.Context(), room.ID(), client)
+	defer span.End()
+	client.session = ctx
+
+	if err := room.Register(client); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "join rejected")
+		if errors.Is(err, ErrBanned) {
+			_ = conn.Close(websocket.StatusPolicyViolation, "banned")
+			return
+		}
+		var full *RoomFullError
+		if errors.As(err, &full) {
+			_ = client.write(ctx, mustMarshal(roomFullMessage{
+				Type:    "error",
+				Code:    "room_full",
+				Message: "room is full",
+				Current: full.Current,
+				Max:     full.Max,
+			}))
+			_ = conn.Close(websocket.StatusTryAgainLater, "room full")
+			return
+		}
+		if errors.Is(err, ErrLobbyFull) {
+			_ = conn.Close(websocket.StatusTryAgainLater, "lobby full")
+			return
+		}
+		_ = conn.Close(websocket.StatusTryAgainLater, "room closed")
 		return
 	}
 
-	client := NewClient(clientUserID, clientName, conn, room)
-	room.Register(client)
+	joinLatency.Observe(time.Since(start).Seconds())
 
-	client.Run(r.Context())
+	client.Run(ctx)
 }
 
 func randomID() string {
@@ -105,12 +842,28 @@ func randomID() string {
 	return hex.EncodeToString(buf)
 }
 
-func generateRoomID() (string, error) {
-	buf := make([]byte, 16)
-	if _, err := rand.Read(buf); err != nil {
-		return "", err
+// clientFingerprint - грубый отпечаток по IP и User-Agent: у гостя нет id,
+// и бан должен пережить переподключение под новым случайным именем.
+func clientFingerprint(r *http.Request) string {
+	ip := clientIP(r)
+
+	sum := sha256.Sum256([]byte(ip + "|" + r.UserAgent()))
+	return hex.EncodeToString(sum[:16])
+}
+
+// clientIP - адрес клиента. X-Real-IP nginx перезаписывает на $remote_addr, а
+// X-Forwarded-For только дополняет тем, что прислал клиент, поэтому ему не верим.
+func clientIP(r *http.Request) string {
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
 	}
-	return hex.EncodeToString(buf), nil
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
 }
 
 func websocketURL(r *http.Request, roomID string) string {
@@ -125,3 +878,35 @@ func websocketURL(r *http.Request, roomID string) string {
 	host := r.Host
 	return scheme + "://" + host + "/ws/" + roomID
 }
+
+func writeRejection(w http.ResponseWriter, err error) {
+	var full *RoomFullError
+	if errors.As(err, &full) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(rejectionResponse{
+			Error:   "room_full",
+			Message: "room is full",
+			Current: full.Current,
+			Max:     full.Max,
+		})
+		return
+	}
+
+	code := "server_full"
+	if errors.Is(err, ErrShuttingDown) {
+		code = "server_shutdown"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_ = json.NewEncoder(w).Encode(rejectionResponse{
+		Error:   code,
+		Message: err.Error(),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(payload)
+}
@@ -0,0 +1,106 @@
+package signaling
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	activeRooms = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "chatter",
+		Subsystem: "signaling",
+		Name:      "active_rooms",
+		Help:      "Rooms currently open on this node.",
+	})
+
+	connectedClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "chatter",
+		Subsystem: "signaling",
+		Name:      "connected_clients",
+		Help:      "WebSocket clients currently connected to this node.",
+	})
+
+	messagesIn = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "chatter",
+		Subsystem: "signaling",
+		Name:      "messages_in_total",
+		Help:      "Messages received from clients by type.",
+	}, []string{"type"})
+
+	messagesOut = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "chatter",
+		Subsystem: "signaling",
+		Name:      "messages_out_total",
+		Help:      "Messages written to clients by type.",
+	}, []string{"type"})
+
+	droppedMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "chatter",
+		Subsystem: "signaling",
+		Name:      "dropped_messages_total",
+		Help:      "Outgoing messages dropped because a client queue was full.",
+	})
+
+	slowConsumerDisconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "chatter",
+		Subsystem: "signaling",
+		Name:      "slow_consumer_disconnects_total",
+		Help:      "Clients disconnected because they could not keep up with signaling traffic.",
+	})
+
+	brokerOpsDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "chatter",
+		Subsystem: "signaling",
+		Name:      "broker_ops_dropped_total",
+		Help:      "Broker writes dropped because a room's broker queue was full.",
+	})
+
+	rejectedOrigins = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "chatter",
+		Subsystem: "signaling",
+		Name:      "rejected_origins_total",
+		Help:      "WebSocket handshakes rejected because the Origin is not allowed.",
+	})
+
+	roomLifetime = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "chatter",
+		Subsystem: "signaling",
+		Name:      "room_lifetime_seconds",
+		Help:      "Time a room stayed open on this node.",
+		Buckets:   prometheus.ExponentialBuckets(10, 4, 8),
+	})
+
+	joinLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "chatter",
+		Subsystem: "signaling",
+		Name:      "join_latency_seconds",
+		Help:      "Time from the join request to the client being registered in the room.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	})
+)
+
+// metricTypes ограничивает значения метки type: тип сообщения присылает клиент,
+// и произвольные строки раздули бы число серий.
+var metricTypes = map[string]struct{}{
+	"chat":            {},
+	"history":         {},
+	"webrtc":          {},
+	"profile":         {},
+	"presence":        {},
+	"participants":    {},
+	"welcome":         {},
+	"error":           {},
+	"moderation":      {},
+	"lobby":           {},
+	"lobby_request":   {},
+	"system":          {},
+	"server_shutdown": {},
+}
+
+func metricType(kind string) string {
+	if _, ok := metricTypes[kind]; ok {
+		return kind
+	}
+
+	return "other"
+}
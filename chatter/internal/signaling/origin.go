@@ -0,0 +1,51 @@
+package signaling
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// originAllowed повторяет правила websocket.Accept для OriginPatterns, чтобы отказ
+// можно было залогировать и посчитать до рукопожатия. Шаблон со схемой ("://")
+// сравнивается со scheme://host, без схемы - только с host; поддерживаются glob'ы path.Match.
+func originAllowed(r *http.Request, patterns []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// не браузер: cookie сам по себе никто не подставит
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	if strings.EqualFold(r.Host, u.Host) {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		target := u.Host
+		if strings.Contains(pattern, "://") {
+			target = u.Scheme + "://" + u.Host
+		}
+		if matched, err := path.Match(strings.ToLower(pattern), strings.ToLower(target)); err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sameOrigin - запрос пришёл со страницы этого же сервера или не из браузера.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(r.Host, u.Host)
+}
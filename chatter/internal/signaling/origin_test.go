@@ -0,0 +1,82 @@
+package signaling
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	patterns := []string{"app.example.com", "*.example.org", "https://secure.example.net", "localhost:*"}
+
+	tests := []struct {
+		name   string
+		host   string
+		origin string
+		want   bool
+	}{
+		{name: "no origin", host: "api.example.com", origin: "", want: true},
+		{name: "same host", host: "api.example.com", origin: "https://api.example.com", want: true},
+		{name: "same host ignores case", host: "API.example.com", origin: "https://api.EXAMPLE.com", want: true},
+		{name: "same host with other port", host: "api.example.com:8080", origin: "https://api.example.com:9090", want: false},
+		{name: "exact host pattern", host: "api.example.com", origin: "https://app.example.com", want: true},
+		{name: "host pattern ignores scheme", host: "api.example.com", origin: "http://app.example.com", want: true},
+		{name: "host pattern does not match port", host: "api.example.com", origin: "https://app.example.com:8443", want: false},
+		{name: "wildcard subdomain", host: "api.example.com", origin: "https://chat.example.org", want: true},
+		{name: "wildcard does not match apex", host: "api.example.com", origin: "https://example.org", want: false},
+		{name: "wildcard matches nested subdomains", host: "api.example.com", origin: "https://a.b.example.org", want: true},
+		{name: "wildcard port", host: "api.example.com", origin: "http://localhost:5173", want: true},
+		{name: "wildcard port requires a port", host: "api.example.com", origin: "http://localhost", want: false},
+		{name: "scheme pattern", host: "api.example.com", origin: "https://secure.example.net", want: true},
+		{name: "scheme pattern rejects other scheme", host: "api.example.com", origin: "http://secure.example.net", want: false},
+		{name: "suffix lookalike", host: "api.example.com", origin: "https://app.example.com.evil.io", want: false},
+		{name: "unknown origin", host: "api.example.com", origin: "https://evil.io", want: false},
+		{name: "origin without host", host: "api.example.com", origin: "null", want: false},
+		{name: "malformed origin", host: "api.example.com", origin: "://bad", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ws/room", nil)
+			r.Host = tt.host
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+
+			if got := originAllowed(r, patterns); got != tt.want {
+				t.Errorf("originAllowed(%q from %q) = %v, want %v", tt.origin, tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		name   string
+		host   string
+		origin string
+		want   bool
+	}{
+		{name: "no origin", host: "api.example.com", origin: "", want: true},
+		{name: "same host", host: "api.example.com", origin: "https://api.example.com", want: true},
+		{name: "same host ignores case", host: "api.example.com", origin: "https://Api.Example.com", want: true},
+		{name: "same host and port", host: "localhost:8080", origin: "http://localhost:8080", want: true},
+		{name: "port mismatch", host: "localhost:8080", origin: "http://localhost:5173", want: false},
+		{name: "missing port", host: "localhost:8080", origin: "http://localhost", want: false},
+		{name: "other host", host: "api.example.com", origin: "https://app.example.com", want: false},
+		{name: "malformed origin", host: "api.example.com", origin: "://bad", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/auth/refresh", nil)
+			r.Host = tt.host
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+
+			if got := sameOrigin(r); got != tt.want {
+				t.Errorf("sameOrigin(%q from %q) = %v, want %v", tt.origin, tt.host, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package signaling
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+)
+
+// Типы сообщений, которые нужны для установки и поддержания звонка.
+// Они обгоняют чат в очереди клиента и не выбрасываются при переполнении.
+var signalingTypes = map[string]struct{}{
+	"welcome":         {},
+	"participants":    {},
+	"presence":        {},
+	"profile":         {},
+	"webrtc":          {},
+	"error":           {},
+	"moderation":      {},
+	"lobby":           {},
+	"lobby_request":   {},
+	"system":          {},
+	"server_shutdown": {},
+}
+
+type outbound struct {
+	data     []byte
+	kind     string
+	priority bool
+	// peer заполнен у webrtc-сообщений: от какого соединения и кому
+	peer webrtcPeer
+	ice  *iceCandidates
+}
+
+type webrtcPeer struct {
+	from string
+	to   string
+}
+
+type iceCandidates struct {
+	from        senderInfo
+	to          string
+	traceparent string
+	candidates  []json.RawMessage
+}
+
+type iceMessage struct {
+	Type       string            `json:"type"`
+	Action     string            `json:"action"`
+	From       senderInfo        `json:"from"`
+	To         string            `json:"to"`
+	Candidate  json.RawMessage   `json:"candidate,omitempty"`
+	Candidates []json.RawMessage `json:"candidates,omitempty"`
+	// при склейке остаётся trace context первого кандидата
+	Traceparent string `json:"traceparent,omitempty"`
+}
+
+// newOutbound - сообщение типа kind, который отправитель знает сам: разбирать данные не нужно.
+func newOutbound(kind string, data []byte) outbound {
+	_, priority := signalingTypes[kind]
+	return outbound{data: data, kind: kind, priority: priority}
+}
+
+// webrtcOutbound - пересылаемое webrtc-сообщение.
+func webrtcOutbound(relay webrtcRelay) outbound {
+	return withPeer(newOutbound("webrtc", mustMarshal(relay)), relay)
+}
+
+// parseOutbound - сообщение с другой ноды: о нём известны только байты, и тип с
+// полями webrtc читаются одним разбором.
+func parseOutbound(data []byte) outbound {
+	var relay webrtcRelay
+	if err := json.Unmarshal(data, &relay); err != nil {
+		return outbound{data: data}
+	}
+
+	item := newOutbound(relay.Type, data)
+	if relay.Type == "webrtc" {
+		item = withPeer(item, relay)
+	}
+
+	return item
+}
+
+// withPeer запоминает, между какими соединениями идёт сигнализация, чтобы outbox
+// мог склеивать ICE-кандидаты.
+func withPeer(item outbound, relay webrtcRelay) outbound {
+	item.peer = webrtcPeer{from: relay.From.ClientID, to: relay.To}
+	if relay.Action == "ice" && len(relay.Candidate) > 0 {
+		item.ice = &iceCandidates{
+			from:        relay.From,
+			to:          relay.To,
+			traceparent: relay.Traceparent,
+			candidates:  []json.RawMessage{relay.Candidate},
+		}
+	}
+
+	return item
+}
+
+func priorityOutbound(payload interface{}) outbound {
+	item := newOutbound(payloadType(payload), mustMarshal(payload))
+	item.priority = true
+	return item
+}
+
+// payloadType - поле Type исходящего сообщения; его объявляют все типы сообщений.
+func payloadType(payload interface{}) string {
+	value := reflect.Indirect(reflect.ValueOf(payload))
+	if value.Kind() != reflect.Struct {
+		return ""
+	}
+
+	field := value.FieldByName("Type")
+	if !field.IsValid() || field.Kind() != reflect.String {
+		return ""
+	}
+
+	return field.String()
+}
+
+func (o outbound) bytes() []byte {
+	if o.ice == nil || len(o.ice.candidates) < 2 {
+		return o.data
+	}
+
+	return mustMarshal(iceMessage{
+		Type:        "webrtc",
+		Action:      "ice",
+		From:        o.ice.from,
+		To:          o.ice.to,
+		Candidates:  o.ice.candidates,
+		Traceparent: o.ice.traceparent,
+	})
+}
+
+type pushResult int
+
+const (
+	pushQueued pushResult = iota
+	pushDropped
+	pushOverflow
+	pushClosed
+)
+
+// outbox - очередь исходящих сообщений клиента с двумя приоритетами.
+// При переполнении сначала жертвуем чатом; если вся очередь занята сигнализацией,
+// клиент не успевает читать и его нужно отключить.
+type outbox struct {
+	mu     sync.Mutex
+	high   []outbound
+	low    []outbound
+	limit  int
+	closed bool
+	ready  chan struct{}
+}
+
+func newOutbox(limit int) *outbox {
+	if limit <= 0 {
+		limit = defaultSendBuffer
+	}
+
+	return &outbox{
+		limit: limit,
+		ready: make(chan struct{}, 1),
+	}
+}
+
+func (o *outbox) push(item outbound) pushResult {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return pushClosed
+	}
+
+	if item.ice != nil {
+		if o.coalesce(item.ice) {
+			return pushQueued
+		}
+		// один и тот же outbound может уйти нескольким клиентам, поэтому копим в своей копии
+		item.ice = &iceCandidates{
+			from:        item.ice.from,
+			to:          item.ice.to,
+			traceparent: item.ice.traceparent,
+			candidates:  append([]json.RawMessage(nil), item.ice.candidates...),
+		}
+	}
+
+	result := pushQueued
+	if len(o.high)+len(o.low) >= o.limit {
+		if !item.priority {
+			return pushDropped
+		}
+		if len(o.low) == 0 {
+			return pushOverflow
+		}
+		o.low = o.low[1:]
+		result = pushDropped
+	}
+
+	if item.priority {
+		o.high = append(o.high, item)
+	} else {
+		o.low = append(o.low, item)
+	}
+
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+
+	return result
+}
+
+// coalesce дописывает ICE-кандидат к ещё не отправленному сообщению того же отправителя.
+// Склеиваем только с последним webrtc-сообщением этой пары: если после ICE в очереди
+// стоит новый offer или answer, кандидат не должен его обогнать.
+func (o *outbox) coalesce(ice *iceCandidates) bool {
+	peer := webrtcPeer{from: ice.from.ClientID, to: ice.to}
+	for i := len(o.high) - 1; i >= 0; i-- {
+		if o.high[i].peer != peer {
+			continue
+		}
+
+		queued := o.high[i].ice
+		if queued == nil {
+			return false
+		}
+		queued.candidates = append(queued.candidates, ice.candidates...)
+		return true
+	}
+
+	return false
+}
+
+func (o *outbox) pop() (outbound, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var item outbound
+	switch {
+	case len(o.high) > 0:
+		item, o.high = o.high[0], o.high[1:]
+	case len(o.low) > 0:
+		item, o.low = o.low[0], o.low[1:]
+	default:
+		return outbound{}, false
+	}
+
+	return item, true
+}
+
+func (o *outbox) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return
+	}
+	o.closed = true
+
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (o *outbox) isClosed() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.closed
+}
@@ -0,0 +1,109 @@
+package signaling
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func relayOutbound(action, from, to string, payload string) outbound {
+	msg := webrtcRelay{
+		webrtcMessage: webrtcMessage{Type: "webrtc", Action: action, To: to},
+		From:          senderInfo{ClientID: from},
+	}
+	if action == "ice" {
+		msg.Candidate = json.RawMessage(payload)
+	} else {
+		msg.SDP = json.RawMessage(payload)
+	}
+
+	return webrtcOutbound(msg)
+}
+
+func drain(t *testing.T, o *outbox) []webrtcRelay {
+	t.Helper()
+
+	var relayed []webrtcRelay
+	for {
+		item, ok := o.pop()
+		if !ok {
+			return relayed
+		}
+
+		var msg webrtcRelay
+		if err := json.Unmarshal(item.bytes(), &msg); err != nil {
+			t.Fatalf("unmarshal %s: %v", item.bytes(), err)
+		}
+		relayed = append(relayed, msg)
+	}
+}
+
+func TestOutboxKeepsIceBehindNewerOffer(t *testing.T) {
+	o := newOutbox(16)
+	o.push(relayOutbound("offer", "a", "b", `"offer-1"`))
+	o.push(relayOutbound("ice", "a", "b", `"ice-1"`))
+	o.push(relayOutbound("offer", "a", "b", `"offer-2"`))
+	o.push(relayOutbound("ice", "a", "b", `"ice-2"`))
+
+	relayed := drain(t, o)
+	want := []string{"offer-1", "ice-1", "offer-2", "ice-2"}
+	if len(relayed) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(relayed), len(want))
+	}
+
+	for i, msg := range relayed {
+		payload := msg.SDP
+		if msg.Action == "ice" {
+			payload = msg.Candidate
+		}
+		if string(payload) != `"`+want[i]+`"` {
+			t.Errorf("message %d: got %s %s, want %s", i, msg.Action, payload, want[i])
+		}
+	}
+}
+
+func TestOutboxCoalescesConsecutiveIce(t *testing.T) {
+	o := newOutbox(16)
+	o.push(relayOutbound("offer", "a", "b", `"offer"`))
+	o.push(relayOutbound("ice", "a", "b", `"ice-1"`))
+	o.push(relayOutbound("ice", "c", "b", `"other"`))
+	o.push(relayOutbound("ice", "a", "b", `"ice-2"`))
+
+	relayed := drain(t, o)
+	if len(relayed) != 3 {
+		t.Fatalf("got %d messages, want 3", len(relayed))
+	}
+
+	if got := relayed[1].Candidates; len(got) != 2 || string(got[0]) != `"ice-1"` || string(got[1]) != `"ice-2"` {
+		t.Errorf("coalesced candidates = %s", got)
+	}
+	if relayed[2].From.ClientID != "c" {
+		t.Errorf("third message from %q, want c", relayed[2].From.ClientID)
+	}
+}
+
+func TestParseOutboundMatchesLocalRelay(t *testing.T) {
+	tests := []struct {
+		name string
+		item outbound
+	}{
+		{"ice", relayOutbound("ice", "a", "b", `"candidate"`)},
+		{"offer", relayOutbound("offer", "a", "b", `"sdp"`)},
+		{"chat", newOutbound("chat", mustMarshal(chatMessage{Type: "chat", Text: "hi"}))},
+		{"presence", priorityOutbound(presenceMessage{Type: "presence", Action: "join"})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// так сообщение приходит с другой ноды
+			parsed := parseOutbound(tt.item.data)
+
+			if parsed.kind != tt.item.kind || parsed.priority != tt.item.priority || parsed.peer != tt.item.peer {
+				t.Errorf("parsed %s: kind %q priority %v peer %v, want %q %v %v",
+					tt.item.data, parsed.kind, parsed.priority, parsed.peer, tt.item.kind, tt.item.priority, tt.item.peer)
+			}
+			if (parsed.ice == nil) != (tt.item.ice == nil) {
+				t.Errorf("parsed ice = %v, want %v", parsed.ice, tt.item.ice)
+			}
+		})
+	}
+}
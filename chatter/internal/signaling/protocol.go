@@ -0,0 +1,184 @@
+package signaling
+
+import (
+	"chatter/internal/domain"
+	"chatter/internal/usecase"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// ProtocolVersion - текущая версия протокола сигнализации, сообщается в welcome.
+	ProtocolVersion = 1
+	// minProtocolVersion - самая старая версия, которую сервер ещё понимает.
+	minProtocolVersion = 1
+)
+
+const maxDisplayName = 64
+
+var ErrUnsupportedProtocol = errors.New("unsupported protocol version")
+
+// negotiateProtocol выбирает версию для клиента: запрошенную, но не новее серверной.
+// Клиент без параметра получает текущую версию.
+func negotiateProtocol(requested string) (int, error) {
+	if requested == "" {
+		return ProtocolVersion, nil
+	}
+
+	version, err := strconv.Atoi(requested)
+	if err != nil || version < minProtocolVersion {
+		return 0, ErrUnsupportedProtocol
+	}
+
+	return min(version, ProtocolVersion), nil
+}
+
+// protocolError - отказ в обработке сообщения клиента; уходит ему в error с кодом и полем.
+type protocolError struct {
+	code    string
+	field   string
+	message string
+}
+
+func (e *protocolError) Error() string {
+	return e.message
+}
+
+func invalidField(field, message string) *protocolError {
+	return &protocolError{code: "invalid_message", field: field, message: message}
+}
+
+func decodeError(err error) *protocolError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return invalidField(typeErr.Field, fmt.Sprintf("%s must not be %s", typeErr.Field, typeErr.Value))
+	}
+
+	return &protocolError{code: "invalid_message", message: "malformed message"}
+}
+
+// inboundMessage - сообщение, которое клиент может прислать серверу.
+type inboundMessage interface {
+	validate() error
+}
+
+// messageSpec описывает тип входящего сообщения: структуру, проверку и обработчик.
+type messageSpec struct {
+	description string
+	payload     reflect.Type
+	decode      func(data []byte) (inboundMessage, error)
+	handle      func(r *Room, ctx context.Context, sender *Client, msg inboundMessage)
+}
+
+func inbound[T any, P interface {
+	*T
+	inboundMessage
+}](description string, handle func(*Room, context.Context, *Client, P)) messageSpec {
+	return messageSpec{
+		description: description,
+		payload:     reflect.TypeFor[T](),
+		decode: func(data []byte) (inboundMessage, error) {
+			msg := P(new(T))
+			if err := json.Unmarshal(data, msg); err != nil {
+				return nil, decodeError(err)
+			}
+			if err := msg.validate(); err != nil {
+				return nil, err
+			}
+			return msg, nil
+		},
+		handle: func(r *Room, ctx context.Context, sender *Client, msg inboundMessage) {
+			handle(r, ctx, sender, msg.(P))
+		},
+	}
+}
+
+// inboundTypes - все типы сообщений, которые принимает сервер. Остальные
+// отклоняются с ошибкой unknown_type.
+var inboundTypes = map[string]messageSpec{
+	"chat":       inbound("Post a chat message to the room.", (*Room).handleChat),
+	"profile":    inbound("Set the display name shown to other participants.", (*Room).handleProfile),
+	"webrtc":     inbound("Relay an SDP offer, answer or ICE candidate to another connection.", (*Room).handleWebRTC),
+	"moderation": inbound("Kick, ban or mute a participant. Owner and moderators only.", (*Room).handleModeration),
+	"lobby":      inbound("Admit or deny a client waiting in the lobby. Owner and moderators only.", (*Room).handleLobby),
+}
+
+// lobbyTypes - что принимаем от клиента, ждущего в лобби: только имя, чтобы хост видел, кто просится войти.
+var lobbyTypes = map[string]struct{}{
+	"profile": {},
+}
+
+func (m *chatRequest) validate() error {
+	if strings.TrimSpace(m.Text) == "" {
+		return invalidField("text", "text is empty")
+	}
+	if utf8.RuneCountInString(m.Text) > usecase.MaxMessageLength {
+		return invalidField("text", "text is too long")
+	}
+
+	return nil
+}
+
+func (m *profileRequest) validate() error {
+	if strings.TrimSpace(m.DisplayName) == "" {
+		return invalidField("displayName", "displayName is empty")
+	}
+	if utf8.RuneCountInString(m.DisplayName) > maxDisplayName {
+		return invalidField("displayName", "displayName is too long")
+	}
+
+	return nil
+}
+
+func (m *webrtcMessage) validate() error {
+	switch m.Action {
+	case "offer", "answer":
+		if len(m.SDP) == 0 {
+			return invalidField("sdp", "sdp is required for "+m.Action)
+		}
+	case "ice":
+		if len(m.Candidate) == 0 {
+			return invalidField("candidate", "candidate is required for ice")
+		}
+	default:
+		return invalidField("action", "unknown webrtc action")
+	}
+
+	if m.To == "" {
+		return invalidField("to", "missing recipient")
+	}
+
+	return nil
+}
+
+func (m *moderationMessage) validate() error {
+	switch m.Action {
+	case domain.ModerationKick, domain.ModerationBan, domain.ModerationMute:
+	default:
+		return invalidField("action", "unknown moderation action")
+	}
+
+	if m.Target == 0 {
+		return invalidField("target", "missing moderation target")
+	}
+
+	return nil
+}
+
+func (m *lobbyMessage) validate() error {
+	if m.Action != "admit" && m.Action != "deny" {
+		return invalidField("action", "unknown lobby action")
+	}
+
+	if m.RequestID == "" {
+		return invalidField("requestId", "missing lobby request id")
+	}
+
+	return nil
+}
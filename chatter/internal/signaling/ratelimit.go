@@ -0,0 +1,257 @@
+package signaling
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	limitWebRTC  = "webrtc"
+	limitChat    = "chat"
+	limitProfile = "profile"
+	limitDefault = "default"
+
+	userBucketsSweepInterval = time.Minute
+)
+
+type RateLimit struct {
+	Rate  float64 `yaml:"rate" env:"RATE"`
+	Burst int     `yaml:"burst" env:"BURST"`
+}
+
+type RateLimits struct {
+	WebRTC  RateLimit `yaml:"webrtc" env-prefix:"WEBRTC_"`
+	Chat    RateLimit `yaml:"chat" env-prefix:"CHAT_"`
+	Profile RateLimit `yaml:"profile" env-prefix:"PROFILE_"`
+	Default RateLimit `yaml:"default" env-prefix:"DEFAULT_"`
+}
+
+func (l RateLimits) forKind(kind string) RateLimit {
+	switch kind {
+	case limitWebRTC:
+		return l.WebRTC
+	case limitChat:
+		return l.Chat
+	case limitProfile:
+		return l.Profile
+	default:
+		return l.Default
+	}
+}
+
+// limitKind - лимит, которым считается сообщение типа messageType.
+func limitKind(messageType string) string {
+	switch messageType {
+	case limitWebRTC, limitChat, limitProfile:
+		return messageType
+	default:
+		return limitDefault
+	}
+}
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	rate   float64
+	burst  float64
+	last   time.Time
+}
+
+// newTokenBucket возвращает nil для нулевого rate: такой лимит не ограничивает ничего.
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	if limit.Rate <= 0 {
+		return nil
+	}
+
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		tokens: burst,
+		rate:   limit.Rate,
+		burst:  burst,
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
+
+func (b *tokenBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// sweepBuckets удаляет полностью восстановившиеся бакеты: такой бакет ничем не отличается от нового.
+func sweepBuckets[K comparable](buckets map[K]*tokenBucket, now time.Time) {
+	for key, bucket := range buckets {
+		if bucket.full(now) {
+			delete(buckets, key)
+		}
+	}
+}
+
+type userBucketKey struct {
+	userID uint64
+	kind   string
+}
+
+// userLimiter делит лимиты между всеми соединениями одного пользователя.
+type userLimiter struct {
+	mu        sync.Mutex
+	limits    RateLimits
+	buckets   map[userBucketKey]*tokenBucket
+	lastSweep time.Time
+}
+
+func newUserLimiter(limits RateLimits) *userLimiter {
+	return &userLimiter{
+		limits:    limits,
+		buckets:   make(map[userBucketKey]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *userLimiter) allow(userID uint64, kind string, now time.Time) bool {
+	l.mu.Lock()
+	if now.Sub(l.lastSweep) > userBucketsSweepInterval {
+		sweepBuckets(l.buckets, now)
+		l.lastSweep = now
+	}
+
+	key := userBucketKey{userID: userID, kind: kind}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = newTokenBucket(l.limits.forKind(kind), now)
+		if bucket == nil {
+			l.mu.Unlock()
+			return true
+		}
+		l.buckets[key] = bucket
+	}
+	l.mu.Unlock()
+
+	return bucket.allow(now)
+}
+
+// keyLimiter - бакет на произвольный ключ, например адрес клиента и комнату.
+type keyLimiter struct {
+	mu        sync.Mutex
+	limit     RateLimit
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newKeyLimiter(limit RateLimit) *keyLimiter {
+	return &keyLimiter{
+		limit:     limit,
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *keyLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	if now.Sub(l.lastSweep) > userBucketsSweepInterval {
+		sweepBuckets(l.buckets, now)
+		l.lastSweep = now
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = newTokenBucket(l.limit, now)
+		if bucket == nil {
+			l.mu.Unlock()
+			return true
+		}
+		l.buckets[key] = bucket
+	}
+	l.mu.Unlock()
+
+	return bucket.allow(now)
+}
+
+type rateLimiter struct {
+	conn       map[string]*tokenBucket
+	user       *userLimiter
+	userID     uint64
+	violations *tokenBucket
+	// now - часы лимитера; тесты подменяют их, чтобы не ждать пополнения бакетов
+	now func() time.Time
+}
+
+func newRateLimiter(cfg Config, user *userLimiter, userID uint64) *rateLimiter {
+	return newRateLimiterAt(cfg, user, userID, time.Now)
+}
+
+func newRateLimiterAt(cfg Config, user *userLimiter, userID uint64, now func() time.Time) *rateLimiter {
+	limiter := &rateLimiter{
+		conn:   make(map[string]*tokenBucket),
+		user:   user,
+		userID: userID,
+		now:    now,
+	}
+
+	start := now()
+	for _, kind := range []string{limitWebRTC, limitChat, limitProfile, limitDefault} {
+		limiter.conn[kind] = newTokenBucket(cfg.RateLimits.forKind(kind), start)
+	}
+
+	if cfg.MaxViolations > 0 && cfg.ViolationWindow > 0 {
+		limiter.violations = newTokenBucket(RateLimit{
+			Rate:  float64(cfg.MaxViolations) / cfg.ViolationWindow.Seconds(),
+			Burst: cfg.MaxViolations,
+		}, start)
+	}
+
+	return limiter
+}
+
+func (l *rateLimiter) allow(kind string) bool {
+	now := l.now()
+	if !l.conn[kind].allow(now) {
+		return false
+	}
+
+	if l.user == nil {
+		return true
+	}
+
+	return l.user.allow(l.userID, kind, now)
+}
+
+// violation учитывает нарушение лимита и сообщает, исчерпан ли запас нарушений.
+func (l *rateLimiter) violation() bool {
+	if l.violations == nil {
+		return false
+	}
+
+	return !l.violations.allow(l.now())
+}
@@ -0,0 +1,243 @@
+package signaling
+
+import (
+	"testing"
+	"time"
+)
+
+// clock - часы, которые идут только по команде теста.
+type clock struct {
+	now time.Time
+}
+
+func newClock() *clock {
+	return &clock{now: time.Now()}
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// tick - шаг сценария: подождать after и проверить, пропустит ли лимит следующий запрос.
+type tick struct {
+	after time.Duration
+	want  bool
+}
+
+func TestTokenBucket(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit RateLimit
+		ticks []tick
+	}{
+		{
+			name:  "burst then refill",
+			limit: RateLimit{Rate: 1, Burst: 2},
+			ticks: []tick{{0, true}, {0, true}, {0, false}, {time.Second, true}, {0, false}},
+		},
+		{
+			name:  "partial refill is not enough",
+			limit: RateLimit{Rate: 1, Burst: 1},
+			ticks: []tick{{0, true}, {500 * time.Millisecond, false}, {500 * time.Millisecond, true}},
+		},
+		{
+			name:  "refill is capped at burst",
+			limit: RateLimit{Rate: 1, Burst: 2},
+			ticks: []tick{{0, true}, {0, true}, {time.Minute, true}, {0, true}, {0, false}},
+		},
+		{
+			name:  "burst below one allows a single request",
+			limit: RateLimit{Rate: 1, Burst: 0},
+			ticks: []tick{{0, true}, {0, false}},
+		},
+		{
+			name:  "zero rate is unlimited",
+			limit: RateLimit{Rate: 0, Burst: 1},
+			ticks: []tick{{0, true}, {0, true}, {0, true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClock()
+			bucket := newTokenBucket(tt.limit, c.Now())
+			for i, step := range tt.ticks {
+				c.advance(step.after)
+				if got := bucket.allow(c.Now()); got != step.want {
+					t.Fatalf("request %d: allow = %v, want %v", i, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterBuckets(t *testing.T) {
+	cfg := Config{RateLimits: RateLimits{
+		Chat:    RateLimit{Rate: 1, Burst: 2},
+		Default: RateLimit{Rate: 1, Burst: 1},
+	}}
+	users := RateLimits{Chat: RateLimit{Rate: 1, Burst: 3}}
+
+	tests := []struct {
+		name string
+		// requests - типы сообщений по очереди: чётные шлёт первое соединение, нечётные - второе
+		requests []string
+		shared   bool
+		want     []bool
+	}{
+		{
+			name:     "connection bucket per kind",
+			requests: []string{limitChat, limitChat, limitChat},
+			want:     []bool{true, true, false},
+		},
+		{
+			name:     "kinds do not share buckets",
+			requests: []string{limitChat, limitChat, limitDefault, limitDefault},
+			want:     []bool{true, true, true, false},
+		},
+		{
+			name:     "unlimited kind",
+			requests: []string{limitWebRTC, limitWebRTC, limitWebRTC},
+			want:     []bool{true, true, true},
+		},
+		{
+			name:     "user bucket is shared between connections",
+			requests: []string{limitChat, limitChat, limitChat, limitChat},
+			shared:   true,
+			want:     []bool{true, true, true, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClock()
+			var user *userLimiter
+			if tt.shared {
+				user = newUserLimiter(users)
+			}
+			limiters := []*rateLimiter{newRateLimiterAt(cfg, user, 7, c.Now)}
+			if tt.shared {
+				limiters = append(limiters, newRateLimiterAt(cfg, user, 7, c.Now))
+			}
+
+			for i, kind := range tt.requests {
+				l := limiters[i%len(limiters)]
+				if got := l.allow(kind); got != tt.want[i] {
+					t.Fatalf("request %d (%s): allow = %v, want %v", i, kind, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// untilDisconnect повторяет решение readLoop: сообщение сверх лимита - нарушение,
+// исчерпанный запас нарушений - отключение. Возвращает номер сообщения, на
+// котором клиента отключили, или -1.
+func untilDisconnect(l *rateLimiter, c *clock, messages int, interval time.Duration) int {
+	for i := 0; i < messages; i++ {
+		if i > 0 {
+			c.advance(interval)
+		}
+		if !l.allow(limitChat) && l.violation() {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func TestRateLimiterDisconnectThreshold(t *testing.T) {
+	limits := RateLimits{Chat: RateLimit{Rate: 1, Burst: 2}}
+
+	tests := []struct {
+		name     string
+		cfg      Config
+		messages int
+		interval time.Duration
+		want     int
+	}{
+		{
+			name:     "flood is cut after the allowed violations",
+			cfg:      Config{RateLimits: limits, MaxViolations: 3, ViolationWindow: 10 * time.Second},
+			messages: 20,
+			// два сообщения в запасе бакета, три нарушения прощаются, четвёртое - отключение
+			want: 5,
+		},
+		{
+			name:     "client within the rate is never cut",
+			cfg:      Config{RateLimits: limits, MaxViolations: 3, ViolationWindow: 10 * time.Second},
+			messages: 100,
+			interval: time.Second,
+			want:     -1,
+		},
+		{
+			name:     "violations recover with the window",
+			cfg:      Config{RateLimits: RateLimits{Chat: RateLimit{Rate: 0.5, Burst: 1}}, MaxViolations: 2, ViolationWindow: 2 * time.Second},
+			messages: 100,
+			// каждое второе сообщение - нарушение, но запас успевает восстановиться
+			interval: time.Second,
+			want:     -1,
+		},
+		{
+			name:     "no violation budget means no disconnect",
+			cfg:      Config{RateLimits: limits},
+			messages: 100,
+			want:     -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClock()
+			l := newRateLimiterAt(tt.cfg, nil, 7, c.Now)
+			if got := untilDisconnect(l, c, tt.messages, tt.interval); got != tt.want {
+				t.Errorf("disconnected at message %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyLimiter(t *testing.T) {
+	c := newClock()
+	l := newKeyLimiter(RateLimit{Rate: 0.1, Burst: 2})
+
+	steps := []struct {
+		key   string
+		after time.Duration
+		want  bool
+	}{
+		{"10.0.0.1|room", 0, true},
+		{"10.0.0.1|room", 0, true},
+		{"10.0.0.1|room", 0, false},
+		// другая комната и другой адрес считаются отдельно
+		{"10.0.0.1|other", 0, true},
+		{"10.0.0.2|room", 0, true},
+		{"10.0.0.1|room", 10 * time.Second, true},
+		{"10.0.0.1|room", 0, false},
+	}
+
+	for i, step := range steps {
+		c.advance(step.after)
+		if got := l.allow(step.key, c.Now()); got != step.want {
+			t.Fatalf("step %d (%s): allow = %v, want %v", i, step.key, got, step.want)
+		}
+	}
+}
+
+func TestKeyLimiterSweepsRecoveredBuckets(t *testing.T) {
+	c := newClock()
+	l := newKeyLimiter(RateLimit{Rate: 1, Burst: 1})
+	l.allow("a", c.Now())
+	l.allow("b", c.Now())
+
+	c.advance(2 * userBucketsSweepInterval)
+	l.allow("c", c.Now())
+
+	if len(l.buckets) != 1 {
+		t.Errorf("%d buckets after sweep, want only the new one", len(l.buckets))
+	}
+}
@@ -0,0 +1,245 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const participantsTTL = 24 * time.Hour
+
+// Пока нода жива, она продлевает свой ключ heartbeat. Если нода упала и не успела
+// убрать участников, ключ истекает, и её записи перестают считаться.
+const (
+	nodeHeartbeatTTL      = 15 * time.Second
+	nodeHeartbeatInterval = 5 * time.Second
+)
+
+type RedisBroker struct {
+	rdb    *redis.Client
+	logger *zap.Logger
+
+	mu sync.Mutex
+	// nodes - ноды этого процесса, чьих участников брокер записывал; их heartbeat продлеваем
+	nodes map[string]struct{}
+
+	stop chan struct{}
+	once sync.Once
+}
+
+func NewRedisBroker(rdb *redis.Client, logger *zap.Logger) *RedisBroker {
+	broker := &RedisBroker{
+		rdb:    rdb,
+		logger: logger,
+		nodes:  make(map[string]struct{}),
+		stop:   make(chan struct{}),
+	}
+
+	go broker.heartbeat()
+	return broker
+}
+
+// Close останавливает heartbeat. Ключи нод истекут сами через nodeHeartbeatTTL.
+func (b *RedisBroker) Close() {
+	b.once.Do(func() {
+		close(b.stop)
+	})
+}
+
+func (b *RedisBroker) heartbeat() {
+	ticker := time.NewTicker(nodeHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.stop:
+			return
+		}
+
+		b.mu.Lock()
+		nodes := make([]string, 0, len(b.nodes))
+		for node := range b.nodes {
+			nodes = append(nodes, node)
+		}
+		b.mu.Unlock()
+
+		for _, node := range nodes {
+			ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+			if err := b.rdb.Set(ctx, nodeKey(node), 1, nodeHeartbeatTTL).Err(); err != nil {
+				b.logger.Warn("Failed to refresh node heartbeat", zap.String("node", node), zap.Error(err))
+			}
+			cancel()
+		}
+	}
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, roomID string, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	if err := b.rdb.Publish(ctx, roomChannel(roomID), data).Err(); err != nil {
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+
+	return nil
+}
+
+func (b *RedisBroker) Subscribe(ctx context.Context, roomID string) (<-chan Event, func(), error) {
+	pubsub := b.rdb.Subscribe(ctx, roomChannel(roomID))
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, nil, fmt.Errorf("failed to subscribe to room: %w", err)
+	}
+
+	events := make(chan Event, 64)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(events)
+
+		for msg := range pubsub.Channel() {
+			var event Event
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				b.logger.Warn("Failed to decode room event", zap.String("roomID", roomID), zap.Error(err))
+				continue
+			}
+
+			select {
+			case events <- event:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	unsubscribe := func() {
+		close(done)
+		_ = pubsub.Close()
+	}
+
+	return events, unsubscribe, nil
+}
+
+func (b *RedisBroker) Join(ctx context.Context, roomID string, participant Participant) error {
+	data, err := json.Marshal(participant)
+	if err != nil {
+		return fmt.Errorf("failed to encode participant: %w", err)
+	}
+
+	b.mu.Lock()
+	b.nodes[participant.Node] = struct{}{}
+	b.mu.Unlock()
+
+	key := participantsKey(roomID)
+	pipe := b.rdb.TxPipeline()
+	pipe.HSet(ctx, key, participantField(participant.Node, participant.ClientID), data)
+	pipe.Expire(ctx, key, participantsTTL)
+	// первый участник не должен ждать тика heartbeat, чтобы его увидели другие ноды
+	pipe.Set(ctx, nodeKey(participant.Node), 1, nodeHeartbeatTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store participant: %w", err)
+	}
+
+	return nil
+}
+
+func (b *RedisBroker) Leave(ctx context.Context, roomID, node, clientID string) error {
+	if err := b.rdb.HDel(ctx, participantsKey(roomID), participantField(node, clientID)).Err(); err != nil {
+		return fmt.Errorf("failed to remove participant: %w", err)
+	}
+
+	return nil
+}
+
+func (b *RedisBroker) Participants(ctx context.Context, roomID string) ([]Participant, error) {
+	values, err := b.rdb.HGetAll(ctx, participantsKey(roomID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list participants: %w", err)
+	}
+
+	participants := make([]Participant, 0, len(values))
+	fields := make([]string, 0, len(values))
+	nodes := map[string]bool{}
+	for field, value := range values {
+		var participant Participant
+		if err := json.Unmarshal([]byte(value), &participant); err != nil {
+			b.logger.Warn("Failed to decode participant", zap.String("roomID", roomID), zap.Error(err))
+			continue
+		}
+		participants = append(participants, participant)
+		fields = append(fields, field)
+		nodes[participant.Node] = false
+	}
+
+	if err := b.aliveNodes(ctx, nodes); err != nil {
+		return nil, err
+	}
+
+	alive := participants[:0]
+	var stale []string
+	for i, participant := range participants {
+		if nodes[participant.Node] {
+			alive = append(alive, participant)
+		} else {
+			stale = append(stale, fields[i])
+		}
+	}
+
+	// записи упавших нод больше некому удалить
+	if len(stale) > 0 {
+		if err := b.rdb.HDel(ctx, participantsKey(roomID), stale...).Err(); err != nil {
+			b.logger.Warn("Failed to remove stale participants", zap.String("roomID", roomID), zap.Error(err))
+		}
+	}
+
+	return alive, nil
+}
+
+// aliveNodes отмечает в nodes те ноды, чей heartbeat ещё не истёк.
+func (b *RedisBroker) aliveNodes(ctx context.Context, nodes map[string]bool) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(nodes))
+	keys := make([]string, 0, len(nodes))
+	for node := range nodes {
+		names = append(names, node)
+		keys = append(keys, nodeKey(node))
+	}
+
+	values, err := b.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return fmt.Errorf("failed to check node heartbeats: %w", err)
+	}
+
+	for i, value := range values {
+		nodes[names[i]] = value != nil
+	}
+
+	return nil
+}
+
+func roomChannel(roomID string) string {
+	return "chatter:room:" + roomID
+}
+
+func participantsKey(roomID string) string {
+	return "chatter:room:" + roomID + ":participants"
+}
+
+func nodeKey(node string) string {
+	return "chatter:node:" + node
+}
+
+func participantField(node, clientID string) string {
+	return node + ":" + clientID
+}
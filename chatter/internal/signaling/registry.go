@@ -1,27 +1,89 @@
 package signaling
 
 import (
+	"chatter/internal/domain"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
 )
 
+// controlChannel - служебный канал брокера для админских команд, которые
+// должны выполниться на всех нодах. В /ws/ такой id комнаты не принимается.
+const controlChannel = "_control"
+
+const drainPollInterval = 50 * time.Millisecond
+
+const (
+	controlCloseRoom      = "close_room"
+	controlDisconnectUser = "disconnect_user"
+	controlAnnounce       = "announce"
+)
+
+var (
+	ErrServerFull   = errors.New("server is at connection capacity")
+	ErrShuttingDown = errors.New("server is shutting down")
+)
+
+type controlCommand struct {
+	Action  string   `json:"action"`
+	RoomIDs []string `json:"roomIds,omitempty"`
+	UserID  uint64   `json:"userId,omitempty"`
+	Text    string   `json:"text,omitempty"`
+}
+
 type Registry struct {
-	mu     sync.RWMutex
-	rooms  map[string]*Room
-	logger *zap.Logger
+	mu       sync.RWMutex
+	rooms    map[string]*Room
+	node     string
+	broker   Broker
+	messages MessageStore
+	audit    AuditStore
+	limits   *userLimiter
+	cfg      Config
+	logger   *zap.Logger
+
+	// slugs - имя -> id для открытых на этой ноде комнат с человекочитаемым именем
+	slugs map[string]string
+
+	connections atomic.Int64
+	draining    atomic.Bool
+	// отписка от канала админских команд
+	unsubscribe func()
 }
 
-func NewRegistry(logger *zap.Logger) *Registry {
-	return &Registry{
-		rooms:  make(map[string]*Room),
-		logger: logger,
+func NewRegistry(broker Broker, messages MessageStore, audit AuditStore, cfg Config, logger *zap.Logger) *Registry {
+	node := randomID()
+
+	registry := &Registry{
+		rooms:    make(map[string]*Room),
+		slugs:    make(map[string]string),
+		node:     node,
+		broker:   broker,
+		messages: messages,
+		audit:    audit,
+		limits:   newUserLimiter(cfg.UserRateLimits),
+		cfg:      cfg,
+		logger:   logger.With(zap.String("node", node)),
 	}
+
+	registry.unsubscribe = registry.listen()
+	return registry
 }
 
-func (r *Registry) GetOrCreate(ctx context.Context, roomID string, userID uint64) *Room {
-	logger := r.logger.With(zap.String("roomID", roomID), zap.Uint64("userID", userID))
+// GetOrCreate возвращает nil, если сервер останавливается.
+func (r *Registry) GetOrCreate(ctx context.Context, info *domain.Room) *Room {
+	if r.draining.Load() {
+		return nil
+	}
+
+	roomID := info.ID
+	logger := r.logger.With(zap.String("roomID", roomID), zap.Uint64("ownerID", info.OwnerID))
 
 	r.mu.RLock()
 	room, ok := r.rooms[roomID]
@@ -33,23 +95,323 @@ func (r *Registry) GetOrCreate(ctx context.Context, roomID string, userID uint64
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if r.draining.Load() {
+		return nil
+	}
 	if room, ok = r.rooms[roomID]; ok {
 		logger.Info("Room already exists")
 		return room
 	}
 
-	room = NewRoom(roomID, r.deleteRoom, r.logger)
+	room = NewRoom(info, r.node, r.broker, r.messages, r.audit, r.cfg, r.deleteRoom, r.logger)
 	r.rooms[roomID] = room
+	if info.Slug != "" {
+		r.slugs[info.Slug] = roomID
+	}
+	activeRooms.Inc()
 
 	logger.Info("Created room")
 
 	return room
 }
 
+// Rooms возвращает комнаты, открытые на этой ноде.
+func (r *Registry) Rooms() []*Room {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rooms := make([]*Room, 0, len(r.rooms))
+	for _, room := range r.rooms {
+		rooms = append(rooms, room)
+	}
+
+	return rooms
+}
+
+// Snapshot возвращает состав комнаты. Если комната на этой ноде не открыта,
+// собираем его из брокера: времени входа там нет.
+func (r *Registry) Snapshot(ctx context.Context, roomID string) (RoomSnapshot, error) {
+	if room, ok := r.Get(roomID); ok {
+		snapshot, err := room.Snapshot(ctx)
+		if !errors.Is(err, ErrRoomClosed) {
+			return snapshot, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, brokerTimeout)
+	defer cancel()
+
+	participants, err := r.broker.Participants(ctx, roomID)
+	if err != nil {
+		return RoomSnapshot{}, err
+	}
+
+	snapshot := RoomSnapshot{ID: roomID, TakenAt: time.Now().UTC()}
+	index := make(map[uint64]int)
+	for _, p := range participants {
+		i, ok := index[p.ID]
+		if !ok {
+			i = len(snapshot.Participants)
+			index[p.ID] = i
+			snapshot.Participants = append(snapshot.Participants, ParticipantSnapshot{
+				ID:          p.ID,
+				DisplayName: p.DisplayName,
+			})
+		}
+		participant := &snapshot.Participants[i]
+		participant.Devices = append(participant.Devices, DeviceSnapshot{ClientID: p.ClientID, Node: p.Node})
+		participant.Connections++
+	}
+
+	return snapshot, nil
+}
+
+// Get ищет открытую комнату по id или по её имени.
+func (r *Registry) Get(roomID string) (*Room, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	room, ok := r.rooms[roomID]
+	if !ok {
+		room, ok = r.rooms[r.slugs[roomID]]
+	}
+	return room, ok
+}
+
+// Occupancy возвращает число занятых мест в комнате. Если комната на этой ноде
+// не открыта, спрашиваем брокер: её могут обслуживать другие ноды.
+func (r *Registry) Occupancy(ctx context.Context, roomID string) int {
+	if room, ok := r.Get(roomID); ok {
+		return room.Occupancy()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, brokerTimeout)
+	defer cancel()
+
+	participants, err := r.broker.Participants(ctx, roomID)
+	if err != nil {
+		r.logger.Error("Failed to load room participants", zap.String("roomID", roomID), zap.Error(err))
+		return 0
+	}
+
+	return len(participants)
+}
+
+func (r *Registry) Capacity(info *domain.Room) int {
+	return r.cfg.roomCapacity(info.Settings)
+}
+
+// Admit проверяет лимиты до апгрейда соединения и занимает слот из MaxConnections.
+// Слот возвращает release: его вызывают, когда соединение закрылось или вход сорвался.
+// Место в комнате окончательно резервирует Room.Register, так что гонка между
+// проверкой и входом безопасна.
+func (r *Registry) Admit(ctx context.Context, info *domain.Room) (release func(), err error) {
+	if r.draining.Load() {
+		return nil, ErrShuttingDown
+	}
+
+	if !r.reserveConnection() {
+		return nil, ErrServerFull
+	}
+
+	capacity := r.Capacity(info)
+	if capacity <= 0 {
+		return r.releaseConnection, nil
+	}
+
+	if current := r.Occupancy(ctx, info.ID); current >= capacity {
+		r.releaseConnection()
+		return nil, &RoomFullError{Current: current, Max: capacity}
+	}
+
+	return r.releaseConnection, nil
+}
+
+// CloseRoom закрывает комнату на всех нодах. Возвращает true, если она была открыта на этой.
+func (r *Registry) CloseRoom(ctx context.Context, roomID, reason string) bool {
+	r.publishControl(ctx, controlCommand{Action: controlCloseRoom, RoomIDs: []string{roomID}, Text: reason})
+	return r.closeRoom(roomID, reason)
+}
+
+// DisconnectUser отключает все сокеты пользователя на всех нодах и возвращает
+// число соединений, закрытых на этой.
+func (r *Registry) DisconnectUser(ctx context.Context, userID uint64, reason string) int {
+	r.publishControl(ctx, controlCommand{Action: controlDisconnectUser, UserID: userID, Text: reason})
+	return r.disconnectUser(userID, reason)
+}
+
+// Announce рассылает системное объявление в указанные комнаты или, если список
+// пуст, во все. Возвращает число комнат этой ноды, получивших объявление.
+func (r *Registry) Announce(ctx context.Context, roomIDs []string, text string) int {
+	r.publishControl(ctx, controlCommand{Action: controlAnnounce, RoomIDs: roomIDs, Text: text})
+	return r.announce(roomIDs, text)
+}
+
+func (r *Registry) closeRoom(roomID, reason string) bool {
+	room, ok := r.Get(roomID)
+	if !ok {
+		return false
+	}
+
+	return room.Close(reason)
+}
+
+func (r *Registry) disconnectUser(userID uint64, reason string) int {
+	count := 0
+	for _, room := range r.Rooms() {
+		count += room.Disconnect(userID, reason)
+	}
+
+	return count
+}
+
+func (r *Registry) announce(roomIDs []string, text string) int {
+	rooms := r.Rooms()
+	if len(roomIDs) > 0 {
+		rooms = rooms[:0]
+		for _, id := range roomIDs {
+			if room, ok := r.Get(id); ok {
+				rooms = append(rooms, room)
+			}
+		}
+	}
+
+	count := 0
+	for _, room := range rooms {
+		if room.Announce(text) {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (r *Registry) publishControl(ctx context.Context, cmd controlCommand) {
+	ctx, cancel := context.WithTimeout(ctx, brokerTimeout)
+	defer cancel()
+
+	event := Event{Node: r.node, Kind: eventControl, Data: mustMarshal(cmd)}
+	if err := r.broker.Publish(ctx, controlChannel, event); err != nil {
+		r.logger.Error("Failed to publish control command", zap.String("action", cmd.Action), zap.Error(err))
+	}
+}
+
+// listen подписывается на админские команды с других нод и возвращает функцию отписки.
+func (r *Registry) listen() func() {
+	ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+	defer cancel()
+
+	events, unsubscribe, err := r.broker.Subscribe(ctx, controlChannel)
+	if err != nil {
+		r.logger.Error("Failed to subscribe to control channel", zap.Error(err))
+		return func() {}
+	}
+
+	if events != nil {
+		go r.handleControl(events)
+	}
+
+	return unsubscribe
+}
+
+func (r *Registry) handleControl(events <-chan Event) {
+	for event := range events {
+		if event.Node == r.node || event.Kind != eventControl {
+			continue
+		}
+
+		var cmd controlCommand
+		if err := json.Unmarshal(event.Data, &cmd); err != nil {
+			r.logger.Warn("Failed to decode control command", zap.Error(err))
+			continue
+		}
+
+		switch cmd.Action {
+		case controlCloseRoom:
+			for _, id := range cmd.RoomIDs {
+				r.closeRoom(id, cmd.Text)
+			}
+		case controlDisconnectUser:
+			r.disconnectUser(cmd.UserID, cmd.Text)
+		case controlAnnounce:
+			r.announce(cmd.RoomIDs, cmd.Text)
+		}
+	}
+}
+
+// Shutdown перестаёт принимать новые подключения и разгоняет клиентов всех комнат
+// с подсказкой переподключиться. Ждёт закрытия комнат, пока не истечёт ctx.
+func (r *Registry) Shutdown(ctx context.Context) error {
+	r.draining.Store(true)
+	r.unsubscribe()
+
+	rooms := r.Rooms()
+	r.logger.Info("Draining signaling rooms", zap.Int("rooms", len(rooms)), zap.Int64("connections", r.connections.Load()))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(rooms))
+	for _, room := range rooms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := room.Shutdown(ctx); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		return fmt.Errorf("failed to drain rooms: %w", err)
+	}
+
+	// даём клиентам получить close-фрейм, пока обработчики соединений не завершились
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for r.connections.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to close connections: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+// reserveConnection занимает слот, если MaxConnections ещё не исчерпан. Проверка
+// и увеличение счётчика атомарны, так что параллельные входы не превысят лимит.
+func (r *Registry) reserveConnection() bool {
+	for {
+		current := r.connections.Load()
+		if r.cfg.MaxConnections > 0 && current >= int64(r.cfg.MaxConnections) {
+			return false
+		}
+		if r.connections.CompareAndSwap(current, current+1) {
+			connectedClients.Inc()
+			return true
+		}
+	}
+}
+
+func (r *Registry) releaseConnection() {
+	r.connections.Add(-1)
+	connectedClients.Dec()
+}
+
+func (r *Registry) rateLimiter(userID uint64) *rateLimiter {
+	return newRateLimiter(r.cfg, r.limits, userID)
+}
+
 func (r *Registry) deleteRoom(roomID string) {
 	r.mu.Lock()
+	if room, ok := r.rooms[roomID]; ok && room.info.Slug != "" {
+		delete(r.slugs, room.info.Slug)
+	}
 	delete(r.rooms, roomID)
 	r.mu.Unlock()
+	activeRooms.Dec()
 
 	r.logger.Info("Room has been emptied and deleted", zap.String("roomID", roomID))
 }
@@ -1,24 +1,132 @@
 package signaling
 
 import (
+	"chatter/internal/domain"
+	"chatter/internal/usecase"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+const maxModerationReason = 200
+
+var (
+	ErrRoomClosed = errors.New("room closed")
+	ErrBanned     = errors.New("banned from room")
+	ErrLobbyFull  = errors.New("room lobby is full")
+)
+
+type RoomFullError struct {
+	Current int
+	Max     int
+}
+
+func (e *RoomFullError) Error() string {
+	return fmt.Sprintf("room is full (%d/%d)", e.Current, e.Max)
+}
+
+type registration struct {
+	client *Client
+	result chan error
+}
+
 type broadcastMessage struct {
 	sender *Client
+	kind   string
 	data   []byte
+	echo   bool
+	// profile - новое имя отправителя, если это сообщение profile
+	profile string
+}
+
+type MessageStore interface {
+	PostMessage(ctx context.Context, roomID string, userID uint64, displayName, text string) (*domain.Message, error)
+	History(ctx context.Context, roomID string, before uint64, limit int) ([]domain.Message, uint64, error)
+}
+
+type AuditStore interface {
+	RecordAction(ctx context.Context, action *domain.ModerationAction) error
+}
+
+type directMessage struct {
+	sender *Client
+	to     string
+	item   outbound
+}
+
+type replyMessage struct {
+	client *Client
+	item   outbound
 }
 
 type messageBase struct {
-	Type string `json:"type"`
+	Type        string `json:"type"`
+	Traceparent string `json:"traceparent,omitempty"`
+}
+
+// senderInfo - отправитель пересылаемого сообщения в том виде, в каком его знает
+// сервер. Поля личности, присланные клиентом, при пересылке выбрасываются.
+type senderInfo struct {
+	ClientID    string `json:"clientId,omitempty"`
+	UserID      uint64 `json:"userId"`
+	DisplayName string `json:"displayName,omitempty"`
+	Guest       bool   `json:"guest,omitempty"`
+}
+
+type webrtcMessage struct {
+	Type        string          `json:"type"`
+	Action      string          `json:"action" enum:"offer,answer,ice"`
+	To          string          `json:"to"`
+	SDP         json.RawMessage `json:"sdp,omitempty"`
+	Candidate   json.RawMessage `json:"candidate,omitempty"`
+	Traceparent string          `json:"traceparent,omitempty"`
+}
+
+// webrtcRelay - webrtc-сообщение в том виде, в каком его получает адресат.
+type webrtcRelay struct {
+	webrtcMessage
+	From senderInfo `json:"from"`
+	// Candidates появляется, когда outbox склеил несколько ICE-кандидатов
+	Candidates []json.RawMessage `json:"candidates,omitempty"`
+}
+
+type errorMessage struct {
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// MessageType и Field - какое сообщение клиента отклонено и из-за какого поля
+	MessageType string `json:"messageType,omitempty"`
+	Field       string `json:"field,omitempty"`
+}
+
+type roomFullMessage struct {
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Current int    `json:"current"`
+	Max     int    `json:"max"`
 }
 
 type welcomeMessage struct {
-	Type     string `json:"type"`
-	ClientID uint64 `json:"clientId"`
+	Type            string `json:"type"`
+	ProtocolVersion int    `json:"protocolVersion"`
+	ClientID        string `json:"clientId"`
+	UserID          uint64 `json:"userId"`
+	ResumeToken     string `json:"resumeToken,omitempty"`
+	GuestToken      string `json:"guestToken,omitempty"`
+	Resumed         bool   `json:"resumed,omitempty"`
 }
 
 type participantsMessage struct {
@@ -26,216 +134,905 @@ type participantsMessage struct {
 	Participants []participantDescriptor `json:"participants"`
 }
 
+const (
+	presenceUser   = "user"
+	presenceDevice = "device"
+)
+
+// presenceMessage сообщает о входе и выходе: scope user - пользователь появился
+// в комнате или ушёл с последнего устройства, scope device - отдельное соединение.
 type presenceMessage struct {
-	Type     string `json:"type"`
-	Action   string `json:"action"`
-	ClientID uint64 `json:"clientId"`
-	TS       string `json:"ts"`
+	Type        string `json:"type"`
+	Action      string `json:"action" enum:"join,leave"`
+	Scope       string `json:"scope" enum:"user,device"`
+	UserID      uint64 `json:"userId"`
+	ClientID    string `json:"clientId,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+	TS          string `json:"ts"`
 }
 
 type profileMessage struct {
-	Type        string `json:"type"`
-	ClientID    uint64 `json:"clientId"`
+	Type        string     `json:"type"`
+	From        senderInfo `json:"from"`
+	DisplayName string     `json:"displayName"`
+}
+
+type profileRequest struct {
 	DisplayName string `json:"displayName"`
 }
 
-type participantDescriptor struct {
-	ID          uint64 `json:"id"`
+type chatMessage struct {
+	Type string `json:"type"`
+	ID   uint64 `json:"id,omitempty"`
+	// у сообщений из истории в From нет соединения: устройство не хранится
+	From        senderInfo `json:"from"`
+	Text        string     `json:"text"`
+	TS          string     `json:"ts,omitempty"`
+	Traceparent string     `json:"traceparent,omitempty"`
+}
+
+type chatRequest struct {
+	Text string `json:"text"`
+}
+
+type historyMessage struct {
+	Type     string        `json:"type"`
+	Messages []chatMessage `json:"messages"`
+}
+
+type moderationMessage struct {
+	Type   string `json:"type"`
+	Action string `json:"action" enum:"kick,ban,mute"`
+	Target uint64 `json:"target"`
+	By     uint64 `json:"by,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	TS     string `json:"ts,omitempty"`
+}
+
+type moderationRequest struct {
+	sender *Client
+	notice moderationMessage
+}
+
+// lobbyMessage - статус ожидающего клиента (status) или решение хоста (action).
+type lobbyMessage struct {
+	Type      string `json:"type"`
+	Action    string `json:"action,omitempty" enum:"admit,deny"`
+	Status    string `json:"status,omitempty"`
+	RequestID string `json:"requestId"`
+}
+
+type lobbyRequestMessage struct {
+	Type        string `json:"type"`
+	RequestID   string `json:"requestId"`
+	UserID      uint64 `json:"userId"`
+	ClientID    string `json:"clientId"`
 	DisplayName string `json:"displayName,omitempty"`
+	Status      string `json:"status"`
+	TS          string `json:"ts"`
+}
+
+type lobbyDecision struct {
+	sender    *Client
+	requestID string
+	action    string
+}
+
+type lobbyEntry struct {
+	requestID   string
+	displayName string
+}
+
+// systemMessage - служебное уведомление от сервера: объявление, закрытие комнаты, отключение.
+type systemMessage struct {
+	Type   string `json:"type"`
+	Event  string `json:"event"`
+	Text   string `json:"text,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	TS     string `json:"ts"`
+}
+
+// shutdownMessage предупреждает клиента об остановке ноды и подсказывает, через
+// сколько переподключаться, чтобы клиенты не пришли на другие ноды все разом.
+type shutdownMessage struct {
+	Type             string `json:"type"`
+	ReconnectAfterMs int64  `json:"reconnectAfterMs"`
+	TS               string `json:"ts"`
+}
+
+const (
+	commandShutdown   = "shutdown"
+	commandClose      = "close"
+	commandDisconnect = "disconnect"
+	commandAnnounce   = "announce"
+)
+
+type roomCommand struct {
+	kind   string
+	userID uint64
+	text   string
+	done   chan int
+}
+
+type participantDescriptor struct {
+	ID          uint64             `json:"id"`
+	DisplayName string             `json:"displayName,omitempty"`
+	Devices     []deviceDescriptor `json:"devices"`
+}
+
+type deviceDescriptor struct {
+	ClientID string `json:"clientId"`
+}
+
+// rosterUpdate - участники комнаты по данным брокера на момент since.
+type rosterUpdate struct {
+	participants []Participant
+	since        time.Time
+	err          error
+}
+
+// suspendedSession держит место клиента, у которого оборвалось соединение,
+// пока он не переподключится с resume-токеном или не истечёт окно ожидания.
+type suspendedSession struct {
+	client *Client
+	buffer []outbound
+	timer  *time.Timer
+}
+
+func (s *suspendedSession) push(item outbound, limit int) {
+	if limit <= 0 {
+		return
+	}
+	if len(s.buffer) >= limit {
+		s.buffer = s.buffer[1:]
+	}
+	s.buffer = append(s.buffer, item)
+}
+
+type RoomSnapshot struct {
+	ID           string
+	Participants []ParticipantSnapshot
+	// Waiting - сколько клиентов ждёт в лобби на этой ноде
+	Waiting int
+	TakenAt time.Time
+}
+
+type ParticipantSnapshot struct {
+	ID          uint64
+	DisplayName string
+	// JoinedAt неизвестен для участников, подключённых только к другим нодам
+	JoinedAt    time.Time
+	Connections int
+	Devices     []DeviceSnapshot
+}
+
+type DeviceSnapshot struct {
+	ClientID string
+	Node     string
+	// JoinedAt известен только для соединений этой ноды
+	JoinedAt time.Time
+}
+
+func (s RoomSnapshot) Has(userID uint64) bool {
+	for _, p := range s.Participants {
+		if p.ID == userID {
+			return true
+		}
+	}
+
+	return false
+}
+
+type RoomStats struct {
+	DroppedMessages uint64
+	SlowConsumers   uint64
 }
 
 type Room struct {
+	info       domain.Room
+	openedAt   time.Time
 	id         string
-	register   chan *Client
+	ownerID    uint64
+	settings   domain.RoomSettings
+	capacity   int
+	node       string
+	broker     Broker
+	messages   MessageStore
+	audit      AuditStore
+	cfg        Config
+	register   chan registration
 	unregister chan *Client
 	broadcast  chan broadcastMessage
+	direct     chan directMessage
+	reply      chan replyMessage
+	moderate   chan moderationRequest
+	decisions  chan lobbyDecision
+	snapshots  chan chan RoomSnapshot
+	commands   chan roomCommand
+	expire     chan string
+	done       chan struct{}
 	onEmpty    func(string)
 	logger     *zap.Logger
+
+	// свежий список участников из брокера: по нему run убирает устройства упавших нод
+	roster chan rosterUpdate
+
+	// записи в брокер run ставит в очередь, а выполняет их brokerLoop: сеть не
+	// задерживает цикл комнаты. published закрывается, когда очередь разобрана.
+	brokerOps chan func()
+	published chan struct{}
+
+	// ждущие в лобби не занимают мест в комнате, поэтому у лобби свой лимит
+	lobbyCapacity int
+
+	// баны действуют, пока жива комната; читаются из JoinRoom до апгрейда соединения
+	bansMu             sync.RWMutex
+	bannedUsers        map[uint64]struct{}
+	bannedFingerprints map[string]struct{}
+
+	dropped       atomic.Uint64
+	slowConsumers atomic.Uint64
+	occupancy     atomic.Int64
+}
+
+func NewRoom(info *domain.Room, node string, broker Broker, messages MessageStore, audit AuditStore, cfg Config, onEmpty func(string), logger *zap.Logger) *Room {
+	room := newRoom(info, node, broker, messages, audit, cfg, onEmpty, logger)
+
+	go room.run()
+	go room.brokerLoop()
+	return room
 }
 
-func NewRoom(id string, onEmpty func(string), logger *zap.Logger) *Room {
-	room := &Room{
-		id:         id,
-		register:   make(chan *Client),
+// newRoom собирает комнату, не запуская её цикл.
+func newRoom(info *domain.Room, node string, broker Broker, messages MessageStore, audit AuditStore, cfg Config, onEmpty func(string), logger *zap.Logger) *Room {
+	return &Room{
+		info:       *info,
+		openedAt:   time.Now(),
+		id:         info.ID,
+		ownerID:    info.OwnerID,
+		settings:   info.Settings,
+		capacity:   cfg.roomCapacity(info.Settings),
+		node:       node,
+		broker:     broker,
+		messages:   messages,
+		audit:      audit,
+		cfg:        cfg,
+		register:   make(chan registration),
 		unregister: make(chan *Client),
-		broadcast:  make(chan broadcastMessage, 32),
+		broadcast:  make(chan broadcastMessage, cfg.roomBuffer()),
+		direct:     make(chan directMessage, cfg.roomBuffer()),
+		reply:      make(chan replyMessage, cfg.roomBuffer()),
+		moderate:   make(chan moderationRequest),
+		decisions:  make(chan lobbyDecision),
+		snapshots:  make(chan chan RoomSnapshot),
+		commands:   make(chan roomCommand),
+		expire:     make(chan string),
+		done:       make(chan struct{}),
 		onEmpty:    onEmpty,
 		logger:     logger,
-	}
 
-	go room.run()
-	return room
+		roster: make(chan rosterUpdate),
+
+		brokerOps: make(chan func(), brokerQueueSize),
+		published: make(chan struct{}),
+
+		lobbyCapacity: cfg.lobbyCapacity(info.Settings),
+
+		bannedUsers:        make(map[uint64]struct{}),
+		bannedFingerprints: make(map[string]struct{}),
+	}
 }
 
 func (r *Room) ID() string {
 	return r.id
 }
 
-func (r *Room) Register(client *Client) {
-	r.register <- client
+func (r *Room) OwnerID() uint64 {
+	return r.ownerID
+}
+
+// Info - метаданные, с которыми комната была открыта.
+func (r *Room) Info() domain.Room {
+	return r.info
+}
+
+// Snapshot собирает состояние комнаты внутри run, поэтому не гоняется с ним за данные.
+func (r *Room) Snapshot(ctx context.Context) (RoomSnapshot, error) {
+	reply := make(chan RoomSnapshot, 1)
+
+	select {
+	case r.snapshots <- reply:
+	case <-r.done:
+		return RoomSnapshot{}, ErrRoomClosed
+	case <-ctx.Done():
+		return RoomSnapshot{}, ctx.Err()
+	}
+
+	select {
+	case snapshot := <-reply:
+		return snapshot, nil
+	case <-ctx.Done():
+		return RoomSnapshot{}, ctx.Err()
+	}
+}
+
+func (r *Room) Stats() RoomStats {
+	return RoomStats{
+		DroppedMessages: r.dropped.Load(),
+		SlowConsumers:   r.slowConsumers.Load(),
+	}
+}
+
+// Occupancy - число занятых мест: живые соединения, отложенные сессии и участники на других нодах.
+func (r *Room) Occupancy() int {
+	return int(r.occupancy.Load())
+}
+
+func (r *Room) Capacity() int {
+	return r.capacity
+}
+
+// CanModerate - владелец комнаты и назначенные модераторы.
+func (r *Room) CanModerate(userID uint64) bool {
+	if userID == 0 {
+		return false
+	}
+
+	return userID == r.ownerID || slices.Contains(r.settings.Moderators, userID)
+}
+
+// IsBanned проверяет бан по id, а для гостей ещё и по отпечатку: гость может
+// выбросить свой токен и прийти с новым id.
+func (r *Room) IsBanned(userID uint64, guest bool, fingerprint string) bool {
+	r.bansMu.RLock()
+	defer r.bansMu.RUnlock()
+
+	if _, ok := r.bannedUsers[userID]; ok && userID != 0 {
+		return true
+	}
+	if _, ok := r.bannedFingerprints[fingerprint]; ok && guest && fingerprint != "" {
+		return true
+	}
+
+	return false
+}
+
+func (r *Room) ban(userID uint64, fingerprints []string) {
+	r.bansMu.Lock()
+	defer r.bansMu.Unlock()
+
+	if userID != 0 {
+		r.bannedUsers[userID] = struct{}{}
+	}
+	for _, fingerprint := range fingerprints {
+		r.bannedFingerprints[fingerprint] = struct{}{}
+	}
+}
+
+// Close рассылает причину закрытия и отключает всех клиентов комнаты на этой ноде.
+func (r *Room) Close(reason string) bool {
+	return r.command(roomCommand{kind: commandClose, text: reason}) >= 0
+}
+
+// Shutdown отключает клиентов этой ноды перед остановкой сервера, подсказывая им
+// переподключиться. Возвращается, когда комната завершилась или истёк ctx.
+func (r *Room) Shutdown(ctx context.Context) error {
+	select {
+	case r.commands <- roomCommand{kind: commandShutdown, done: make(chan int, 1)}:
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// участники этой ноды должны уйти из брокера до того, как его закроют
+	select {
+	case <-r.published:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Disconnect отключает все соединения пользователя и возвращает их число.
+func (r *Room) Disconnect(userID uint64, reason string) int {
+	return max(r.command(roomCommand{kind: commandDisconnect, userID: userID, text: reason}), 0)
+}
+
+// Announce отправляет системное объявление всем в комнате.
+func (r *Room) Announce(text string) bool {
+	return r.command(roomCommand{kind: commandAnnounce, text: text}) >= 0
+}
+
+// command возвращает -1, если комната уже закрылась.
+func (r *Room) command(cmd roomCommand) int {
+	cmd.done = make(chan int, 1)
+
+	select {
+	case r.commands <- cmd:
+		return <-cmd.done
+	case <-r.done:
+		return -1
+	}
+}
+
+// Register возвращает ErrRoomClosed, если комната уже закрылась и клиенту нужно
+// переподключиться, *RoomFullError, если мест не осталось, и ErrBanned для забаненных.
+func (r *Room) Register(client *Client) error {
+	reg := registration{client: client, result: make(chan error, 1)}
+	select {
+	case r.register <- reg:
+		return <-reg.result
+	case <-r.done:
+		return ErrRoomClosed
+	}
 }
 
 func (r *Room) Unregister(client *Client) {
-	r.unregister <- client
+	select {
+	case r.unregister <- client:
+	case <-r.done:
+	}
 }
 
-func (r *Room) Broadcast(sender *Client, data []byte) {
-	r.broadcast <- broadcastMessage{sender: sender, data: data}
+func (r *Room) Broadcast(sender *Client, kind string, data []byte) {
+	r.send(broadcastMessage{sender: sender, kind: kind, data: data})
 }
 
-func (r *Room) HandleIncoming(sender *Client, data []byte) {
-	var base messageBase
-	if err := json.Unmarshal(data, &base); err == nil {
-		if base.Type == "presence" || base.Type == "participants" || base.Type == "welcome" {
-			return
-		}
+func (r *Room) SendTo(sender *Client, to string, item outbound) {
+	select {
+	case r.direct <- directMessage{sender: sender, to: to, item: item}:
+	case <-r.done:
+	}
+}
 
-		if base.Type == "profile" {
-			var msg profileMessage
-			if err := json.Unmarshal(data, &msg); err != nil {
-				return
-			}
+func (r *Room) send(msg broadcastMessage) {
+	select {
+	case r.broadcast <- msg:
+	case <-r.done:
+	}
+}
 
-			if msg.DisplayName == "" {
-				return
-			}
+func (r *Room) sendError(client *Client, code, message string) {
+	r.sendReply(client, errorMessage{Type: "error", Code: code, Message: message})
+}
 
-			r.broadcast <- broadcastMessage{
-				sender: sender,
-				data:   mustMarshal(profileMessage{Type: "profile", ClientID: sender.ID(), DisplayName: msg.DisplayName}),
-			}
+// reject отвечает на отклонённое сообщение клиента структурированной ошибкой.
+func (r *Room) reject(client *Client, kind string, err error) {
+	var protoErr *protocolError
+	if !errors.As(err, &protoErr) {
+		protoErr = &protocolError{code: "invalid_message", message: err.Error()}
+	}
+
+	r.sendReply(client, errorMessage{
+		Type:        "error",
+		Code:        protoErr.code,
+		Message:     protoErr.message,
+		MessageType: kind,
+		Field:       protoErr.field,
+	})
+}
+
+func (r *Room) sendReply(client *Client, payload interface{}) {
+	select {
+	case r.reply <- replyMessage{client: client, item: priorityOutbound(payload)}:
+	case <-r.done:
+	}
+}
+
+// HandleIncoming обрабатывает сообщение клиента, конверт которого readLoop уже разобрал.
+func (r *Room) HandleIncoming(sender *Client, base messageBase, data []byte) {
+	messagesIn.WithLabelValues(metricType(base.Type)).Inc()
 
+	ctx, span := startMessageSpan(sender, r.id, base.Type, base.Traceparent)
+	defer span.End()
+
+	spec, ok := inboundTypes[base.Type]
+	if !ok {
+		span.SetStatus(codes.Error, "unknown message type")
+		r.reject(sender, base.Type, &protocolError{code: "unknown_type", field: "type", message: "unknown message type"})
+		return
+	}
+
+	if sender.waiting.Load() {
+		if _, ok := lobbyTypes[base.Type]; !ok {
 			return
 		}
 	}
 
-	r.logger.Info("Received message", zap.String("roomID", r.ID()), zap.Uint64("clientUserID", sender.ID()), zap.String("data", string(data)))
-	r.Broadcast(sender, data)
+	msg, err := spec.decode(data)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid message")
+		r.reject(sender, base.Type, err)
+		return
+	}
+
+	spec.handle(r, ctx, sender, msg)
 }
 
-func (r *Room) run() {
-	clients := make(map[*Client]struct{})
-	participants := make(map[uint64]struct{})
-	displayNames := make(map[uint64]string)
+// rejectMalformed отвечает на фрейм, который не разобрался как JSON-объект.
+func (r *Room) rejectMalformed(sender *Client) {
+	messagesIn.WithLabelValues(metricType("")).Inc()
 
-	sendToClient := func(client *Client, payload interface{}) {
-		data, err := json.Marshal(payload)
-		if err != nil {
-			return
-		}
+	_, span := startMessageSpan(sender, r.id, "", "")
+	defer span.End()
+	span.SetStatus(codes.Error, "malformed message")
 
-		select {
-		case client.send <- data:
-		default:
-			close(client.send)
-			delete(clients, client)
-		}
+	r.reject(sender, "", &protocolError{code: "invalid_message", message: "message is not a JSON object"})
+}
+
+func (r *Room) handleProfile(_ context.Context, sender *Client, msg *profileRequest) {
+	sender.displayName = msg.DisplayName
+
+	r.send(broadcastMessage{
+		sender: sender,
+		kind:   "profile",
+		data: mustMarshal(profileMessage{
+			Type:        "profile",
+			From:        sender.identity(),
+			DisplayName: msg.DisplayName,
+		}),
+		profile: msg.DisplayName,
+	})
+}
+
+func (r *Room) handleLobby(_ context.Context, sender *Client, msg *lobbyMessage) {
+	if !r.CanModerate(sender.ID()) {
+		r.sendError(sender, "forbidden", "only the owner or a moderator can do this")
+		return
 	}
 
-	sendToAll := func(payload interface{}, skip *Client) {
-		data, err := json.Marshal(payload)
+	select {
+	case r.decisions <- lobbyDecision{sender: sender, requestID: msg.RequestID, action: msg.Action}:
+	case <-r.done:
+	}
+}
+
+func (r *Room) handleChat(ctx context.Context, sender *Client, msg *chatRequest) {
+	span := trace.SpanFromContext(ctx)
+	if r.settings.DisableChat {
+		span.SetStatus(codes.Error, "chat disabled")
+		r.sendError(sender, "chat_disabled", "chat is disabled in this room")
+		return
+	}
+
+	// имя берём то, что знает сервер, а не присланное в сообщении
+	from := sender.identity()
+	out := chatMessage{
+		Type: "chat",
+		From: from,
+		Text: msg.Text,
+		TS:   time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if r.messages != nil {
+		ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+		defer cancel()
+
+		stored, err := r.messages.PostMessage(ctx, r.id, sender.ID(), from.DisplayName, msg.Text)
 		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to store message")
+			if errors.Is(err, usecase.ErrInvalidMessage) {
+				r.sendError(sender, "invalid_message", "message is empty or too long")
+			} else {
+				r.sendError(sender, "chat_unavailable", "failed to store message")
+			}
 			return
 		}
+		out = chatFromDomain(stored)
+	}
+	out.From = from
+	out.Traceparent = traceparent(ctx)
 
-		for client := range clients {
-			if client == skip {
-				continue
-			}
-			select {
-			case client.send <- data:
-			default:
-				close(client.send)
-				delete(clients, client)
-			}
-		}
+	r.send(broadcastMessage{sender: sender, kind: "chat", data: mustMarshal(out), echo: true})
+}
+
+// sendHistory отправляет вошедшему клиенту последние сообщения чата. Сообщения,
+// пришедшие, пока история загружалась, клиент уже получил и не дублирует.
+func (r *Room) sendHistory(client *Client) {
+	if r.messages == nil || r.cfg.HistorySize <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	messages, _, err := r.messages.History(ctx, r.id, 0, r.cfg.HistorySize)
+	if err != nil {
+		r.logger.Error("Failed to load chat history", zap.String("roomID", r.id), zap.Error(err))
+		return
+	}
+	if len(messages) == 0 {
+		return
 	}
 
+	history := make([]chatMessage, 0, len(messages))
+	for i := range messages {
+		history = append(history, chatFromDomain(&messages[i]))
+	}
+	r.sendReply(client, historyMessage{
+		Type:     "history",
+		Messages: history,
+	})
+}
+
+func chatFromDomain(message *domain.Message) chatMessage {
+	return chatMessage{
+		Type: "chat",
+		ID:   message.ID,
+		From: senderInfo{
+			UserID:      message.UserID,
+			DisplayName: message.DisplayName,
+			Guest:       domain.IsGuestID(message.UserID),
+		},
+		Text: message.Text,
+		TS:   message.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+func (r *Room) handleWebRTC(ctx context.Context, sender *Client, msg *webrtcMessage) {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("webrtc.action", msg.Action),
+		attribute.String("webrtc.to", msg.To),
+	)
+
+	relay := webrtcRelay{webrtcMessage: *msg, From: sender.identity()}
+	// получатель вернёт этот traceparent в answer/ice, и обмен свяжется в один трейс
+	if value := traceparent(ctx); value != "" {
+		relay.Traceparent = value
+	}
+
+	r.SendTo(sender, msg.To, webrtcOutbound(relay))
+}
+
+func (r *Room) handleModeration(_ context.Context, sender *Client, msg *moderationMessage) {
+	if !r.CanModerate(sender.ID()) {
+		r.sendError(sender, "forbidden", "only the owner or a moderator can do this")
+		return
+	}
+
+	if msg.Target == sender.ID() {
+		r.sendError(sender, "invalid_target", "invalid moderation target")
+		return
+	}
+
+	if msg.Target == r.ownerID {
+		r.sendError(sender, "forbidden", "the room owner cannot be moderated")
+		return
+	}
+
+	reason := strings.TrimSpace(msg.Reason)
+	if utf8.RuneCountInString(reason) > maxModerationReason {
+		reason = string([]rune(reason)[:maxModerationReason])
+	}
+
+	select {
+	case r.moderate <- moderationRequest{
+		sender: sender,
+		notice: moderationMessage{
+			Type:   "moderation",
+			Action: msg.Action,
+			Target: msg.Target,
+			By:     sender.ID(),
+			Reason: reason,
+			TS:     time.Now().UTC().Format(time.RFC3339),
+		},
+	}:
+	case <-r.done:
+	}
+}
+
+func (r *Room) run() {
+	logger := r.logger.With(zap.String("roomID", r.id))
+
+	events, unsubscribe, err := r.subscribe()
+	if err != nil {
+		logger.Error("Failed to subscribe to room events", zap.Error(err))
+		events, unsubscribe = nil, func() {}
+	}
+	defer unsubscribe()
+	defer close(r.brokerOps)
+	defer close(r.done)
+	defer func() {
+		roomLifetime.Observe(time.Since(r.openedAt).Seconds())
+	}()
+
+	state := newRoomState(r, logger)
+	if participants, err := r.remoteParticipants(); err == nil {
+		state.addRemote(participants)
+	}
+
+	// presence leave от упавшей ноды не придёт, поэтому список чужих устройств
+	// периодически сверяется с брокером, который не отдаёт участников мёртвых нод
+	refresh := time.NewTicker(rosterInterval)
+	defer refresh.Stop()
+	fetching := false
+
 	for {
+		r.occupancy.Store(int64(state.occupied()))
+
 		select {
-		case client := <-r.register:
-			clients[client] = struct{}{}
-			participants[client.ID()] = struct{}{}
-			sendToClient(client, welcomeMessage{
-				Type:     "welcome",
-				ClientID: client.ID(),
-			})
-			ids := make([]participantDescriptor, 0, len(participants))
-			for id := range participants {
-				ids = append(ids, participantDescriptor{
-					ID:          id,
-					DisplayName: displayNames[id],
-				})
-			}
-			sendToClient(client, participantsMessage{
-				Type:         "participants",
-				Participants: ids,
-			})
-			sendToAll(presenceMessage{
-				Type:     "presence",
-				Action:   "join",
-				ClientID: client.ID(),
-				TS:       time.Now().UTC().Format(time.RFC3339),
-			}, client)
+		case reg := <-r.register:
+			state.register(reg)
 		case client := <-r.unregister:
-			if _, ok := clients[client]; ok {
-				delete(clients, client)
-				close(client.send)
+			if state.unregister(client) {
+				return
 			}
-
-			hasOther := false
-			for c := range clients {
-				if c.ID() == client.ID() {
-					hasOther = true
-					break
-				}
+		case token := <-r.expire:
+			if state.expire(token) {
+				return
 			}
-
-			if !hasOther {
-				if _, ok := participants[client.ID()]; ok {
-					delete(participants, client.ID())
-					delete(displayNames, client.ID())
-					sendToAll(presenceMessage{
-						Type:     "presence",
-						Action:   "leave",
-						ClientID: client.ID(),
-						TS:       time.Now().UTC().Format(time.RFC3339),
-					}, nil)
-				}
+		case msg := <-r.direct:
+			state.direct(msg)
+		case req := <-r.moderate:
+			if state.moderate(req) {
+				return
 			}
-			if len(clients) == 0 {
-				if r.onEmpty != nil {
-					r.onEmpty(r.id)
-				}
+		case cmd := <-r.commands:
+			if state.command(cmd) {
 				return
 			}
+		case reply := <-r.snapshots:
+			reply <- state.snapshot()
+		case decision := <-r.decisions:
+			state.decision(decision)
+		case msg := <-r.reply:
+			state.reply(msg)
 		case msg := <-r.broadcast:
-			if msg.sender != nil && len(msg.data) > 0 {
-				var base messageBase
-				if err := json.Unmarshal(msg.data, &base); err == nil && base.Type == "profile" {
-					var profile profileMessage
-					if err := json.Unmarshal(msg.data, &profile); err == nil {
-						if profile.DisplayName != "" {
-							displayNames[msg.sender.ID()] = profile.DisplayName
-						}
-					}
-				}
+			state.broadcast(msg)
+		case <-refresh.C:
+			if !fetching {
+				fetching = true
+				go r.fetchRoster(time.Now())
+			}
+		case update := <-r.roster:
+			fetching = false
+			// без ответа брокера нельзя отличить упавшую ноду от живой
+			if update.err == nil {
+				state.reconcile(update.participants, update.since)
 			}
-			for client := range clients {
-				if client == msg.sender {
-					continue
-				}
-				select {
-				case client.send <- msg.data:
-				default:
-					close(client.send)
-					delete(clients, client)
-				}
+		case event, ok := <-events:
+			if !ok {
+				logger.Warn("Room event subscription closed")
+				events = nil
+				continue
+			}
+			if state.event(event) {
+				return
 			}
 		}
 	}
 }
 
+// record пишет действие модератора в аудит, не задерживая цикл комнаты.
+func (r *Room) record(notice moderationMessage, fingerprints []string) {
+	if r.audit == nil {
+		return
+	}
+
+	action := &domain.ModerationAction{
+		RoomID:            r.id,
+		Action:            notice.Action,
+		ActorID:           notice.By,
+		TargetID:          notice.Target,
+		TargetFingerprint: strings.Join(fingerprints, ","),
+		Reason:            notice.Reason,
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+
+		_ = r.audit.RecordAction(ctx, action)
+	}()
+}
+
+func (r *Room) subscribe() (<-chan Event, func(), error) {
+	ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+	defer cancel()
+
+	return r.broker.Subscribe(ctx, r.id)
+}
+
+func (r *Room) remoteParticipants() ([]Participant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+	defer cancel()
+
+	participants, err := r.broker.Participants(ctx, r.id)
+	if err != nil {
+		r.logger.Error("Failed to load room participants", zap.String("roomID", r.id), zap.Error(err))
+		return nil, err
+	}
+
+	return participants, nil
+}
+
+// fetchRoster читает участников вне цикла комнаты, чтобы медленный брокер его не задерживал.
+func (r *Room) fetchRoster(since time.Time) {
+	participants, err := r.remoteParticipants()
+
+	select {
+	case r.roster <- rosterUpdate{participants: participants, since: since, err: err}:
+	case <-r.done:
+	}
+}
+
+// brokerLoop по порядку выполняет записи в брокер, поставленные run. Очередь
+// закрывается вместе с комнатой, и оставшиеся в ней записи ещё выполняются.
+func (r *Room) brokerLoop() {
+	defer close(r.published)
+
+	for op := range r.brokerOps {
+		op()
+	}
+}
+
+// enqueue не блокирует run: если брокер так медленный, что очередь заполнилась,
+// запись теряется.
+func (r *Room) enqueue(op func()) {
+	select {
+	case r.brokerOps <- op:
+	default:
+		brokerOpsDropped.Inc()
+		r.logger.Warn("Broker queue is full, dropping operation", zap.String("roomID", r.id))
+	}
+}
+
+func (r *Room) publish(event Event) {
+	event.Node = r.node
+	r.enqueue(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+		defer cancel()
+
+		if err := r.broker.Publish(ctx, r.id, event); err != nil {
+			r.logger.Error("Failed to publish room event", zap.String("roomID", r.id), zap.String("kind", event.Kind), zap.Error(err))
+		}
+	})
+}
+
+func (r *Room) join(client *Client, displayName string) {
+	participant := Participant{ID: client.ID(), ClientID: client.ClientID(), DisplayName: displayName, Node: r.node}
+	r.enqueue(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+		defer cancel()
+
+		if err := r.broker.Join(ctx, r.id, participant); err != nil {
+			r.logger.Error("Failed to store room participant", zap.String("roomID", r.id), zap.Uint64("userID", participant.ID), zap.Error(err))
+		}
+	})
+}
+
+func (r *Room) leave(clientID string) {
+	r.enqueue(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+		defer cancel()
+
+		if err := r.broker.Leave(ctx, r.id, r.node, clientID); err != nil {
+			r.logger.Error("Failed to remove room participant", zap.String("roomID", r.id), zap.String("clientID", clientID), zap.Error(err))
+		}
+	})
+}
+
+func presenceData(action, scope string, userID uint64, clientID, displayName string) []byte {
+	return mustMarshal(presenceMessage{
+		Type:        "presence",
+		Action:      action,
+		Scope:       scope,
+		UserID:      userID,
+		ClientID:    clientID,
+		DisplayName: displayName,
+		TS:          time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
 func mustMarshal(payload interface{}) []byte {
 	data, err := json.Marshal(payload)
 	if err != nil {
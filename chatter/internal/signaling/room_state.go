@@ -0,0 +1,885 @@
+package signaling
+
+import (
+	"chatter/internal/domain"
+	"encoding/json"
+	"time"
+
+	"github.com/coder/websocket"
+	"go.uber.org/zap"
+)
+
+// roomState - всё, что меняется в комнате по ходу жизни. Им владеет только
+// горутина run, поэтому методы работают без блокировок.
+type roomState struct {
+	room   *Room
+	logger *zap.Logger
+
+	clients   map[*Client]struct{}
+	suspended map[string]*suspendedSession
+	// клиенты в лобби ждут решения хоста: трафика комнаты не получают и в presence не видны
+	lobby        map[*Client]*lobbyEntry
+	participants map[uint64]struct{}
+	displayNames map[uint64]string
+	joinedAt     map[uint64]time.Time
+	// устройства участников на других нодах: id пользователя -> id соединения -> нода
+	remote map[uint64]map[string]string
+	// когда нода узнала о чужом устройстве: сверка со старым списком брокера его не трогает
+	remoteSeen map[string]time.Time
+}
+
+func newRoomState(room *Room, logger *zap.Logger) *roomState {
+	return &roomState{
+		room:         room,
+		logger:       logger,
+		clients:      make(map[*Client]struct{}),
+		suspended:    make(map[string]*suspendedSession),
+		lobby:        make(map[*Client]*lobbyEntry),
+		participants: make(map[uint64]struct{}),
+		displayNames: make(map[uint64]string),
+		joinedAt:     make(map[uint64]time.Time),
+		remote:       make(map[uint64]map[string]string),
+		remoteSeen:   make(map[string]time.Time),
+	}
+}
+
+// addRemote запоминает участников других нод, которых брокер знал на момент открытия комнаты.
+func (s *roomState) addRemote(participants []Participant) {
+	for _, p := range participants {
+		if p.Node == s.room.node || p.ClientID == "" {
+			continue
+		}
+		s.trackRemote(p.ID, p.ClientID, p.Node)
+		if p.DisplayName != "" {
+			s.displayNames[p.ID] = p.DisplayName
+		}
+	}
+}
+
+func (s *roomState) trackRemote(id uint64, clientID, node string) {
+	if s.remote[id] == nil {
+		s.remote[id] = make(map[string]string)
+	}
+	s.remote[id][clientID] = node
+	s.remoteSeen[clientID] = time.Now()
+}
+
+// dropRemote забывает устройство другой ноды и рассылает его presence leave,
+// а если у пользователя больше нет соединений - и уход пользователя.
+func (s *roomState) dropRemote(id uint64, clientID string, presence []byte) {
+	if _, ok := s.remote[id][clientID]; !ok {
+		return
+	}
+	delete(s.remote[id], clientID)
+	delete(s.remoteSeen, clientID)
+	if len(s.remote[id]) == 0 {
+		delete(s.remote, id)
+	}
+
+	s.sendToAll(newOutbound("presence", presence), nil)
+	if !s.present(id) {
+		delete(s.displayNames, id)
+		s.sendToAll(newOutbound("presence", presenceData("leave", presenceUser, id, "", "")), nil)
+	}
+}
+
+// reconcile убирает устройства других нод, которых нет в списке брокера на момент since.
+// Устройства, о которых нода узнала позже, список ещё не мог учесть.
+func (s *roomState) reconcile(participants []Participant, since time.Time) {
+	listed := make(map[string]struct{}, len(participants))
+	for _, p := range participants {
+		listed[p.ClientID] = struct{}{}
+	}
+
+	for id, devices := range s.remote {
+		for clientID, node := range devices {
+			if _, ok := listed[clientID]; ok || s.remoteSeen[clientID].After(since) {
+				continue
+			}
+
+			s.logger.Info("Dropping device of unreachable node", zap.Uint64("userID", id), zap.String("clientID", clientID), zap.String("node", node))
+			s.dropRemote(id, clientID, presenceData("leave", presenceDevice, id, clientID, ""))
+		}
+	}
+}
+
+func (s *roomState) present(id uint64) bool {
+	if _, ok := s.participants[id]; ok {
+		return true
+	}
+
+	return len(s.remote[id]) > 0
+}
+
+func (s *roomState) remoteDevice(clientID string) bool {
+	for _, devices := range s.remote {
+		if _, ok := devices[clientID]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *roomState) deliver(client *Client, item outbound) {
+	r := s.room
+	switch client.out.push(item) {
+	case pushDropped:
+		r.dropped.Add(1)
+		droppedMessages.Inc()
+	case pushOverflow:
+		r.dropped.Add(1)
+		r.slowConsumers.Add(1)
+		droppedMessages.Inc()
+		slowConsumerDisconnects.Inc()
+		delete(s.clients, client)
+		client.out.close()
+		s.logger.Warn("Client cannot keep up, disconnecting", zap.Uint64("userID", client.ID()), zap.Uint64("dropped", r.dropped.Load()))
+		go client.disconnect(websocket.StatusTryAgainLater, "slow consumer")
+	}
+}
+
+func (s *roomState) sendToClient(client *Client, payload interface{}) {
+	s.deliver(client, priorityOutbound(payload))
+}
+
+func (s *roomState) sendToAll(item outbound, skip *Client) {
+	for client := range s.clients {
+		if client == skip {
+			continue
+		}
+		s.deliver(client, item)
+	}
+	for _, session := range s.suspended {
+		session.push(item, s.room.cfg.ResumeBuffer)
+	}
+}
+
+// devices - соединения пользователя: живые и отложенные на этой ноде и на других нодах.
+func (s *roomState) devices(id uint64) []deviceDescriptor {
+	list := make([]deviceDescriptor, 0, 1)
+	for client := range s.clients {
+		if client.ID() == id {
+			list = append(list, deviceDescriptor{ClientID: client.ClientID()})
+		}
+	}
+	for _, session := range s.suspended {
+		if session.client.ID() == id {
+			list = append(list, deviceDescriptor{ClientID: session.client.ClientID()})
+		}
+	}
+	for clientID := range s.remote[id] {
+		list = append(list, deviceDescriptor{ClientID: clientID})
+	}
+
+	return list
+}
+
+func (s *roomState) participantList() participantsMessage {
+	ids := make([]participantDescriptor, 0, len(s.participants)+len(s.remote))
+	for id := range s.participants {
+		ids = append(ids, participantDescriptor{
+			ID:          id,
+			DisplayName: s.displayNames[id],
+			Devices:     s.devices(id),
+		})
+	}
+	for id := range s.remote {
+		if _, ok := s.participants[id]; ok {
+			continue
+		}
+		ids = append(ids, participantDescriptor{
+			ID:          id,
+			DisplayName: s.displayNames[id],
+			Devices:     s.devices(id),
+		})
+	}
+
+	return participantsMessage{
+		Type:         "participants",
+		Participants: ids,
+	}
+}
+
+func (s *roomState) connected(id uint64) bool {
+	for client := range s.clients {
+		if client.ID() == id {
+			return true
+		}
+	}
+	for _, session := range s.suspended {
+		if session.client.ID() == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *roomState) depart(id uint64) {
+	if s.connected(id) {
+		return
+	}
+	if _, ok := s.participants[id]; !ok {
+		return
+	}
+
+	delete(s.participants, id)
+	delete(s.joinedAt, id)
+	if !s.present(id) {
+		delete(s.displayNames, id)
+		s.sendToAll(newOutbound("presence", presenceData("leave", presenceUser, id, "", "")), nil)
+	}
+}
+
+// detach снимает с учёта соединение, которое ушло насовсем: сообщает об уходе
+// устройства, а если у пользователя больше нет соединений - и об уходе пользователя.
+func (s *roomState) detach(client *Client) {
+	// клиенты из лобби в комнату так и не вошли
+	if client.detached || client.joinedAt.IsZero() {
+		return
+	}
+	client.detached = true
+
+	s.room.leave(client.ClientID())
+	presence := presenceData("leave", presenceDevice, client.ID(), client.ClientID(), "")
+	s.room.publish(Event{Kind: eventPresence, Sender: client.ID(), Data: presence})
+	s.sendToAll(newOutbound("presence", presence), nil)
+	s.depart(client.ID())
+}
+
+// resume переносит клиента в старый слот: из отложенной сессии или из ещё живого
+// соединения, обрыв которого сервер пока не заметил.
+func (s *roomState) resume(client *Client) ([]outbound, bool) {
+	token := client.resumeToken
+	if token == "" {
+		return nil, false
+	}
+
+	// новое соединение занимает id старого, чтобы собеседникам не пришлось заново
+	// устанавливать звонок
+	if session, ok := s.suspended[token]; ok && session.client.ID() == client.ID() {
+		session.timer.Stop()
+		delete(s.suspended, token)
+		session.client.detached = true
+		client.clientID = session.client.clientID
+		client.joinedAt = session.client.joinedAt
+		return session.buffer, true
+	}
+
+	for old := range s.clients {
+		if old.resumeToken == token && old.ID() == client.ID() {
+			delete(s.clients, old)
+			old.detached = true
+			client.clientID = old.clientID
+			client.joinedAt = old.joinedAt
+			old.out.close()
+			go old.disconnect(websocket.StatusNormalClosure, "session resumed")
+			return nil, true
+		}
+	}
+
+	return nil, false
+}
+
+// suspend держит место оборвавшегося клиента ResumeGrace; по истечении окна
+// токен приходит в r.expire.
+func (s *roomState) suspend(client *Client) {
+	r := s.room
+	token := client.resumeToken
+	s.suspended[token] = &suspendedSession{
+		client: client,
+		timer: time.AfterFunc(r.cfg.ResumeGrace, func() {
+			select {
+			case r.expire <- token:
+			case <-r.done:
+			}
+		}),
+	}
+}
+
+// evict отключает все соединения пользователя, включая отложенные сессии, и
+// возвращает их число и отпечатки гостевых подключений.
+func (s *roomState) evict(id uint64, code websocket.StatusCode, reason string) (int, []string) {
+	var (
+		evicted      []*Client
+		fingerprints []string
+	)
+	for client := range s.clients {
+		if client.ID() != id {
+			continue
+		}
+		evicted = append(evicted, client)
+		delete(s.clients, client)
+		client.out.close()
+		go client.closeAfterFlush(code, reason)
+	}
+	for token, session := range s.suspended {
+		if session.client.ID() != id {
+			continue
+		}
+		evicted = append(evicted, session.client)
+		session.timer.Stop()
+		delete(s.suspended, token)
+	}
+
+	for _, client := range evicted {
+		if client.guest && client.fingerprint != "" {
+			fingerprints = append(fingerprints, client.fingerprint)
+		}
+		s.detach(client)
+	}
+
+	return len(evicted), fingerprints
+}
+
+// enforce выкидывает цель кика или бана из комнаты и возвращает отпечатки её гостевых подключений.
+func (s *roomState) enforce(notice moderationMessage) []string {
+	if notice.Action != domain.ModerationKick && notice.Action != domain.ModerationBan {
+		return nil
+	}
+
+	_, fingerprints := s.evict(notice.Target, websocket.StatusPolicyViolation, notice.Action)
+	if notice.Action == domain.ModerationBan {
+		s.room.ban(notice.Target, fingerprints)
+	}
+
+	return fingerprints
+}
+
+func (s *roomState) occupied() int {
+	count := len(s.clients) + len(s.suspended)
+	for _, nodes := range s.remote {
+		count += len(nodes)
+	}
+
+	return count
+}
+
+func (s *roomState) admit(client *Client) {
+	r := s.room
+	client.resumeToken = randomID()
+	client.joinedAt = time.Now().UTC()
+	known := s.present(client.ID())
+	s.clients[client] = struct{}{}
+	if _, ok := s.participants[client.ID()]; !ok {
+		s.participants[client.ID()] = struct{}{}
+		s.joinedAt[client.ID()] = client.joinedAt
+	}
+	r.join(client, s.displayNames[client.ID()])
+
+	s.sendToClient(client, welcomeMessage{
+		Type:            "welcome",
+		ProtocolVersion: client.protocol,
+		ClientID:        client.ClientID(),
+		UserID:          client.ID(),
+		ResumeToken:     client.resumeToken,
+		GuestToken:      client.guestToken,
+	})
+	s.sendToClient(client, s.participantList())
+	// историю нужно только вошедшим, и база не должна задерживать цикл комнаты
+	go r.sendHistory(client)
+
+	if !known {
+		s.sendToAll(newOutbound("presence", presenceData("join", presenceUser, client.ID(), "", s.displayNames[client.ID()])), client)
+	}
+	presence := presenceData("join", presenceDevice, client.ID(), client.ClientID(), s.displayNames[client.ID()])
+	s.sendToAll(newOutbound("presence", presence), client)
+	r.publish(Event{Kind: eventPresence, Sender: client.ID(), Data: presence})
+
+	// хосту, пришедшему позже, показываем тех, кто уже ждёт
+	if r.CanModerate(client.ID()) {
+		for waiting, entry := range s.lobby {
+			s.sendToClient(client, lobbyRequestMessage{
+				Type:        "lobby_request",
+				RequestID:   entry.requestID,
+				UserID:      waiting.ID(),
+				ClientID:    waiting.ClientID(),
+				DisplayName: entry.displayName,
+				Status:      "pending",
+				TS:          time.Now().UTC().Format(time.RFC3339),
+			})
+		}
+	}
+}
+
+func (s *roomState) notifyHosts(data []byte) {
+	for client := range s.clients {
+		if s.room.CanModerate(client.ID()) {
+			s.deliver(client, newOutbound("lobby_request", data))
+		}
+	}
+}
+
+func (s *roomState) announce(client *Client, entry *lobbyEntry, status string) {
+	data := mustMarshal(lobbyRequestMessage{
+		Type:        "lobby_request",
+		RequestID:   entry.requestID,
+		UserID:      client.ID(),
+		ClientID:    client.ClientID(),
+		DisplayName: entry.displayName,
+		Status:      status,
+		TS:          time.Now().UTC().Format(time.RFC3339),
+	})
+	s.notifyHosts(data)
+	s.room.publish(Event{Kind: eventLobby, Sender: client.ID(), Data: data})
+}
+
+// decide применяет решение хоста к клиенту из лобби этой ноды.
+func (s *roomState) decide(requestID, action string) bool {
+	r := s.room
+	for client, entry := range s.lobby {
+		if entry.requestID != requestID {
+			continue
+		}
+		delete(s.lobby, client)
+		client.waiting.Store(false)
+
+		if action == "admit" && r.capacity > 0 && s.occupied() >= r.capacity {
+			s.sendToClient(client, roomFullMessage{
+				Type:    "error",
+				Code:    "room_full",
+				Message: "room is full",
+				Current: s.occupied(),
+				Max:     r.capacity,
+			})
+			client.out.close()
+			go client.closeAfterFlush(websocket.StatusTryAgainLater, "room full")
+			s.announce(client, entry, "denied")
+			return true
+		}
+
+		if action == "admit" {
+			s.admit(client)
+			s.announce(client, entry, "admitted")
+			s.logger.Info("Client admitted from lobby", zap.Uint64("userID", client.ID()))
+			return true
+		}
+
+		s.sendToClient(client, lobbyMessage{Type: "lobby", Status: "denied", RequestID: requestID})
+		client.out.close()
+		go client.closeAfterFlush(websocket.StatusPolicyViolation, "denied by host")
+		s.announce(client, entry, "denied")
+		s.logger.Info("Client denied from lobby", zap.Uint64("userID", client.ID()))
+		return true
+	}
+
+	return false
+}
+
+// empty сообщает, что в комнате на этой ноде никого не осталось; тогда run должен завершиться.
+func (s *roomState) empty() bool {
+	if len(s.clients) > 0 || len(s.suspended) > 0 || len(s.lobby) > 0 {
+		return false
+	}
+	if s.room.onEmpty != nil {
+		s.room.onEmpty(s.room.id)
+	}
+
+	return true
+}
+
+// closeAll отправляет каждому клиенту последнее сообщение, отключает всех и
+// снимает участников этой ноды в брокере. После него run должен завершиться.
+func (s *roomState) closeAll(notice func(*Client) outbound, code websocket.StatusCode, reason string) {
+	for client := range s.clients {
+		s.deliver(client, notice(client))
+		client.out.close()
+		go client.closeAfterFlush(code, reason)
+	}
+	for client := range s.lobby {
+		s.deliver(client, notice(client))
+		client.out.close()
+		go client.closeAfterFlush(code, reason)
+	}
+	gone := make([]*Client, 0, len(s.clients)+len(s.suspended))
+	for client := range s.clients {
+		gone = append(gone, client)
+	}
+	for _, session := range s.suspended {
+		session.timer.Stop()
+		gone = append(gone, session.client)
+	}
+
+	s.clients = map[*Client]struct{}{}
+	s.lobby = map[*Client]*lobbyEntry{}
+	s.suspended = map[string]*suspendedSession{}
+	for _, client := range gone {
+		s.detach(client)
+	}
+	s.empty()
+}
+
+// updateProfile запоминает имя из profile, пришедшего с другой ноды
+func (s *roomState) updateProfile(senderID uint64, data []byte) {
+	var profile profileMessage
+	if err := json.Unmarshal(data, &profile); err != nil || profile.Type != "profile" || profile.DisplayName == "" {
+		return
+	}
+
+	s.displayNames[senderID] = profile.DisplayName
+}
+
+// register решает судьбу нового соединения: возврат в старый слот, отказ, лобби или вход.
+func (s *roomState) register(reg registration) {
+	r := s.room
+	client := reg.client
+	if buffered, ok := s.resume(client); ok {
+		reg.result <- nil
+		s.clients[client] = struct{}{}
+		client.resumeToken = randomID()
+		s.sendToClient(client, welcomeMessage{
+			Type:            "welcome",
+			ProtocolVersion: client.protocol,
+			ClientID:        client.ClientID(),
+			UserID:          client.ID(),
+			ResumeToken:     client.resumeToken,
+			GuestToken:      client.guestToken,
+			Resumed:         true,
+		})
+		s.sendToClient(client, s.participantList())
+		for _, item := range buffered {
+			s.deliver(client, item)
+		}
+
+		s.logger.Info("Client resumed session", zap.Uint64("userID", client.ID()), zap.Int("replayed", len(buffered)))
+		return
+	}
+
+	if r.capacity > 0 && s.occupied() >= r.capacity {
+		s.logger.Info("Room is full, rejecting client", zap.Uint64("userID", client.ID()), zap.Int("capacity", r.capacity))
+		reg.result <- &RoomFullError{Current: s.occupied(), Max: r.capacity}
+		return
+	}
+	if r.IsBanned(client.ID(), client.guest, client.fingerprint) {
+		reg.result <- ErrBanned
+		return
+	}
+	if r.settings.Lobby && !r.CanModerate(client.ID()) {
+		if r.lobbyCapacity > 0 && len(s.lobby) >= r.lobbyCapacity {
+			s.logger.Info("Lobby is full, rejecting client", zap.Uint64("userID", client.ID()), zap.Int("capacity", r.lobbyCapacity))
+			reg.result <- ErrLobbyFull
+			return
+		}
+
+		entry := &lobbyEntry{requestID: randomID(), displayName: client.username}
+		s.lobby[client] = entry
+		client.waiting.Store(true)
+		reg.result <- nil
+
+		s.sendToClient(client, lobbyMessage{Type: "lobby", Status: "waiting", RequestID: entry.requestID})
+		s.announce(client, entry, "pending")
+
+		s.logger.Info("Client is waiting in lobby", zap.Uint64("userID", client.ID()))
+		return
+	}
+	reg.result <- nil
+
+	s.admit(client)
+}
+
+// unregister убирает закрытое соединение. Оборвавшийся клиент ещё ResumeGrace
+// держит своё место. Возвращает true, если комната опустела.
+func (s *roomState) unregister(client *Client) bool {
+	if entry, ok := s.lobby[client]; ok {
+		delete(s.lobby, client)
+		client.out.close()
+		s.announce(client, entry, "left")
+		return s.empty()
+	}
+
+	_, active := s.clients[client]
+	if active {
+		delete(s.clients, client)
+		client.out.close()
+	}
+
+	if active && !client.leaving && s.room.cfg.ResumeGrace > 0 {
+		s.suspend(client)
+		s.logger.Info("Client connection lost, holding session for resume", zap.Uint64("userID", client.ID()))
+		return false
+	}
+
+	s.detach(client)
+	return s.empty()
+}
+
+// expire освобождает место, которое так и не заняли повторно. Возвращает true, если комната опустела.
+func (s *roomState) expire(token string) bool {
+	session, ok := s.suspended[token]
+	if !ok {
+		return false
+	}
+	delete(s.suspended, token)
+
+	s.logger.Info("Resume window expired", zap.Uint64("userID", session.client.ID()))
+	s.detach(session.client)
+	return s.empty()
+}
+
+func (s *roomState) direct(msg directMessage) {
+	item := msg.item
+	delivered := false
+	for client := range s.clients {
+		if client == msg.sender || client.ClientID() != msg.to {
+			continue
+		}
+		delivered = true
+		s.deliver(client, item)
+	}
+	for _, session := range s.suspended {
+		if session.client.ClientID() == msg.to {
+			delivered = true
+			session.push(item, s.room.cfg.ResumeBuffer)
+		}
+	}
+	if !delivered && s.remoteDevice(msg.to) {
+		s.room.publish(Event{Kind: eventDirect, Sender: msg.sender.ID(), ToClient: msg.to, Data: msg.item.data})
+		delivered = true
+	}
+	if !delivered {
+		if _, ok := s.clients[msg.sender]; ok {
+			s.sendToClient(msg.sender, errorMessage{
+				Type:    "error",
+				Code:    "recipient_unavailable",
+				Message: "recipient is not in the room",
+			})
+		}
+	}
+}
+
+// moderate применяет действие модератора этой ноды. Возвращает true, если комната опустела.
+func (s *roomState) moderate(req moderationRequest) bool {
+	data := mustMarshal(req.notice)
+	s.sendToAll(newOutbound("moderation", data), nil)
+	s.room.publish(Event{Kind: eventModeration, Sender: req.sender.ID(), To: req.notice.Target, Data: data})
+	fingerprints := s.enforce(req.notice)
+	s.room.record(req.notice, fingerprints)
+
+	s.logger.Info("Moderation action applied",
+		zap.String("action", req.notice.Action),
+		zap.Uint64("actorID", req.notice.By),
+		zap.Uint64("targetID", req.notice.Target),
+	)
+	return s.empty()
+}
+
+// command выполняет административную команду. Возвращает true, если комната завершилась.
+func (s *roomState) command(cmd roomCommand) bool {
+	r := s.room
+	ts := time.Now().UTC().Format(time.RFC3339)
+
+	switch cmd.kind {
+	case commandAnnounce:
+		s.sendToAll(priorityOutbound(systemMessage{Type: "system", Event: "announcement", Text: cmd.text, TS: ts}), nil)
+		cmd.done <- 1
+	case commandDisconnect:
+		notice := priorityOutbound(systemMessage{Type: "system", Event: "disconnected", Reason: cmd.text, TS: ts})
+		for client := range s.clients {
+			if client.ID() == cmd.userID {
+				s.deliver(client, notice)
+			}
+		}
+		count, _ := s.evict(cmd.userID, websocket.StatusNormalClosure, "disconnected by administrator")
+		cmd.done <- count
+
+		s.logger.Info("User disconnected by administrator", zap.Uint64("userID", cmd.userID), zap.Int("connections", count))
+		return s.empty()
+	case commandClose:
+		notice := priorityOutbound(systemMessage{Type: "system", Event: "room_closed", Reason: cmd.text, TS: ts})
+		s.closeAll(func(*Client) outbound { return notice }, websocket.StatusNormalClosure, "room closed")
+		cmd.done <- 0
+
+		s.logger.Info("Room closed by administrator", zap.String("reason", cmd.text))
+		return true
+	case commandShutdown:
+		s.closeAll(func(*Client) outbound {
+			return priorityOutbound(shutdownMessage{
+				Type:             "server_shutdown",
+				ReconnectAfterMs: r.cfg.reconnectDelay().Milliseconds(),
+				TS:               ts,
+			})
+		}, websocket.StatusGoingAway, "server shutting down")
+		cmd.done <- 0
+
+		s.logger.Info("Room drained for shutdown")
+		return true
+	}
+
+	return false
+}
+
+func (s *roomState) snapshot() RoomSnapshot {
+	r := s.room
+	snapshot := RoomSnapshot{
+		ID:      r.id,
+		Waiting: len(s.lobby),
+		TakenAt: time.Now().UTC(),
+	}
+	local := make(map[uint64][]DeviceSnapshot)
+	for client := range s.clients {
+		local[client.ID()] = append(local[client.ID()], DeviceSnapshot{ClientID: client.ClientID(), Node: r.node, JoinedAt: client.joinedAt})
+	}
+	for _, session := range s.suspended {
+		client := session.client
+		local[client.ID()] = append(local[client.ID()], DeviceSnapshot{ClientID: client.ClientID(), Node: r.node, JoinedAt: client.joinedAt})
+	}
+	for id := range s.participants {
+		participant := ParticipantSnapshot{
+			ID:          id,
+			DisplayName: s.displayNames[id],
+			JoinedAt:    s.joinedAt[id],
+			Devices:     local[id],
+		}
+		for clientID, node := range s.remote[id] {
+			participant.Devices = append(participant.Devices, DeviceSnapshot{ClientID: clientID, Node: node})
+		}
+		participant.Connections = len(participant.Devices)
+		snapshot.Participants = append(snapshot.Participants, participant)
+	}
+	for id, devices := range s.remote {
+		if _, ok := s.participants[id]; ok {
+			continue
+		}
+		participant := ParticipantSnapshot{
+			ID:          id,
+			DisplayName: s.displayNames[id],
+		}
+		for clientID, node := range devices {
+			participant.Devices = append(participant.Devices, DeviceSnapshot{ClientID: clientID, Node: node})
+		}
+		participant.Connections = len(participant.Devices)
+		snapshot.Participants = append(snapshot.Participants, participant)
+	}
+
+	return snapshot
+}
+
+func (s *roomState) decision(decision lobbyDecision) {
+	if !s.decide(decision.requestID, decision.action) {
+		// клиент мог ждать в лобби на другой ноде
+		s.room.publish(Event{Kind: eventLobby, Sender: decision.sender.ID(), Data: mustMarshal(lobbyMessage{
+			Type:      "lobby",
+			Action:    decision.action,
+			RequestID: decision.requestID,
+		})})
+	}
+}
+
+func (s *roomState) reply(msg replyMessage) {
+	if _, ok := s.clients[msg.client]; ok {
+		s.deliver(msg.client, msg.item)
+	}
+}
+
+func (s *roomState) broadcast(msg broadcastMessage) {
+	if entry, ok := s.lobby[msg.sender]; ok {
+		if msg.profile != "" {
+			entry.displayName = msg.profile
+			s.announce(msg.sender, entry, "pending")
+		}
+		return
+	}
+	if msg.sender != nil && msg.profile != "" {
+		s.displayNames[msg.sender.ID()] = msg.profile
+		for client := range s.clients {
+			if client.ID() == msg.sender.ID() {
+				s.room.join(client, msg.profile)
+			}
+		}
+	}
+	skip := msg.sender
+	if msg.echo {
+		skip = nil
+	}
+	s.sendToAll(newOutbound(msg.kind, msg.data), skip)
+	if msg.sender != nil {
+		s.room.publish(Event{Kind: eventBroadcast, Sender: msg.sender.ID(), Data: msg.data})
+	}
+}
+
+// event применяет событие с другой ноды. Возвращает true, если комната опустела.
+func (s *roomState) event(event Event) bool {
+	if event.Node == s.room.node {
+		return false
+	}
+
+	switch event.Kind {
+	case eventBroadcast:
+		s.updateProfile(event.Sender, event.Data)
+		s.sendToAll(parseOutbound(event.Data), nil)
+	case eventDirect:
+		item := parseOutbound(event.Data)
+		for client := range s.clients {
+			if client.ClientID() == event.ToClient {
+				s.deliver(client, item)
+			}
+		}
+		for _, session := range s.suspended {
+			if session.client.ClientID() == event.ToClient {
+				session.push(item, s.room.cfg.ResumeBuffer)
+			}
+		}
+	case eventLobby:
+		s.onLobby(event.Data)
+	case eventModeration:
+		var notice moderationMessage
+		if err := json.Unmarshal(event.Data, &notice); err != nil {
+			return false
+		}
+
+		s.sendToAll(newOutbound("moderation", event.Data), nil)
+		s.enforce(notice)
+		return s.empty()
+	case eventPresence:
+		s.onPresence(event.Node, event.Data)
+	}
+
+	return false
+}
+
+func (s *roomState) onLobby(data []byte) {
+	var base messageBase
+	if err := json.Unmarshal(data, &base); err != nil {
+		return
+	}
+
+	switch base.Type {
+	case "lobby_request":
+		s.notifyHosts(data)
+	case "lobby":
+		var decision lobbyMessage
+		if err := json.Unmarshal(data, &decision); err == nil {
+			s.decide(decision.RequestID, decision.Action)
+		}
+	}
+}
+
+// onPresence учитывает вход и выход устройства на другой ноде.
+func (s *roomState) onPresence(node string, data []byte) {
+	var presence presenceMessage
+	if err := json.Unmarshal(data, &presence); err != nil {
+		return
+	}
+
+	// между нодами ходят только события устройств; о пользователе каждая
+	// нода сообщает сама, потому что он может быть подключён и к ней
+	if presence.Scope != presenceDevice || presence.ClientID == "" {
+		return
+	}
+
+	id := presence.UserID
+	switch presence.Action {
+	case "join":
+		if presence.DisplayName != "" {
+			s.displayNames[id] = presence.DisplayName
+		}
+		if !s.present(id) {
+			s.sendToAll(newOutbound("presence", presenceData("join", presenceUser, id, "", s.displayNames[id])), nil)
+		}
+		s.trackRemote(id, presence.ClientID, node)
+		s.sendToAll(newOutbound("presence", data), nil)
+	case "leave":
+		s.dropRemote(id, presence.ClientID, data)
+	}
+}
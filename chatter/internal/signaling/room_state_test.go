@@ -0,0 +1,498 @@
+package signaling
+
+import (
+	"chatter/internal/domain"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"go.uber.org/zap"
+)
+
+// frame - поля исходящего сообщения, по которым тесты узнают, что клиент получил
+type frame struct {
+	Type      string `json:"type"`
+	Action    string `json:"action"`
+	Scope     string `json:"scope"`
+	Status    string `json:"status"`
+	RequestID string `json:"requestId"`
+	UserID    uint64 `json:"userId"`
+}
+
+func (f frame) String() string {
+	parts := []string{f.Type}
+	for _, part := range []string{f.Action, f.Scope, f.Status} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// testConn - настоящее соединение с сервером, который только читает: комната
+// закрывает соединения выгнанных клиентов из отдельных горутин.
+func testConn(t *testing.T) *websocket.Conn {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		for {
+			if _, _, err := conn.Read(context.Background()); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.CloseNow() })
+
+	return conn
+}
+
+func testClient(t *testing.T, room *Room, userID uint64) *Client {
+	t.Helper()
+
+	return &Client{
+		userID:   userID,
+		username: "user",
+		clientID: randomID(),
+		conn:     testConn(t),
+		room:     room,
+		out:      newOutbox(64),
+		cfg:      room.cfg,
+		logger:   zap.NewNop(),
+		protocol: ProtocolVersion,
+		flushed:  make(chan struct{}),
+	}
+}
+
+func testRoom(settings domain.RoomSettings, cfg Config) *Room {
+	info := &domain.Room{ID: "room", OwnerID: 1, Settings: settings}
+	return newRoom(info, "node-a", NewMemoryBroker(), nil, nil, cfg, nil, zap.NewNop())
+}
+
+func received(t *testing.T, client *Client) []frame {
+	t.Helper()
+
+	var frames []frame
+	for {
+		item, ok := client.out.pop()
+		if !ok {
+			return frames
+		}
+
+		var f frame
+		if err := json.Unmarshal(item.bytes(), &f); err != nil {
+			t.Fatalf("unmarshal %s: %v", item.bytes(), err)
+		}
+		frames = append(frames, f)
+	}
+}
+
+func kinds(frames []frame) []string {
+	list := make([]string, 0, len(frames))
+	for _, f := range frames {
+		list = append(list, f.String())
+	}
+
+	return list
+}
+
+func register(state *roomState, client *Client) error {
+	result := make(chan error, 1)
+	state.register(registration{client: client, result: result})
+	return <-result
+}
+
+func TestRoomStateAdmitAnnouncesUserOnce(t *testing.T) {
+	room := testRoom(domain.RoomSettings{}, Config{})
+	state := newRoomState(room, zap.NewNop())
+
+	host := testClient(t, room, 1)
+	if err := register(state, host); err != nil {
+		t.Fatalf("register host: %v", err)
+	}
+	if got := kinds(received(t, host)); strings.Join(got, ",") != "welcome,participants" {
+		t.Fatalf("host received %v, want welcome and participants", got)
+	}
+
+	phone := testClient(t, room, 2)
+	if err := register(state, phone); err != nil {
+		t.Fatalf("register phone: %v", err)
+	}
+	if got := kinds(received(t, host)); strings.Join(got, ",") != "presence:join:user,presence:join:device" {
+		t.Errorf("first device: host received %v", got)
+	}
+
+	laptop := testClient(t, room, 2)
+	if err := register(state, laptop); err != nil {
+		t.Fatalf("register laptop: %v", err)
+	}
+	if got := kinds(received(t, host)); strings.Join(got, ",") != "presence:join:device" {
+		t.Errorf("second device: host received %v", got)
+	}
+	if got := len(state.devices(2)); got != 2 {
+		t.Errorf("user has %d devices, want 2", got)
+	}
+}
+
+func TestRoomStateRegisterRejects(t *testing.T) {
+	room := testRoom(domain.RoomSettings{MaxParticipants: 1}, Config{})
+	state := newRoomState(room, zap.NewNop())
+
+	if err := register(state, testClient(t, room, 1)); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	var full *RoomFullError
+	if err := register(state, testClient(t, room, 2)); !errors.As(err, &full) || full.Current != 1 || full.Max != 1 {
+		t.Errorf("register over capacity: error = %v, want room full 1/1", err)
+	}
+
+	room = testRoom(domain.RoomSettings{}, Config{})
+	state = newRoomState(room, zap.NewNop())
+	room.ban(2, nil)
+	if err := register(state, testClient(t, room, 2)); !errors.Is(err, ErrBanned) {
+		t.Errorf("register banned: error = %v, want %v", err, ErrBanned)
+	}
+}
+
+func TestRoomStateLobbyDecisions(t *testing.T) {
+	room := testRoom(domain.RoomSettings{Lobby: true}, Config{})
+	state := newRoomState(room, zap.NewNop())
+
+	host := testClient(t, room, 1)
+	if err := register(state, host); err != nil {
+		t.Fatalf("register host: %v", err)
+	}
+	received(t, host)
+
+	admitted := testClient(t, room, 2)
+	denied := testClient(t, room, 3)
+	for _, client := range []*Client{admitted, denied} {
+		if err := register(state, client); err != nil {
+			t.Fatalf("register %d: %v", client.ID(), err)
+		}
+		if !client.waiting.Load() {
+			t.Fatalf("client %d is not waiting in lobby", client.ID())
+		}
+	}
+
+	requests := make(map[uint64]string)
+	for _, f := range received(t, host) {
+		if f.Type == "lobby_request" && f.Status == "pending" {
+			requests[f.UserID] = f.RequestID
+		}
+	}
+	if len(requests) != 2 {
+		t.Fatalf("host saw %d lobby requests, want 2", len(requests))
+	}
+
+	state.decision(lobbyDecision{sender: host, requestID: requests[2], action: "admit"})
+	state.decision(lobbyDecision{sender: host, requestID: requests[3], action: "deny"})
+
+	if _, ok := state.clients[admitted]; !ok || admitted.waiting.Load() {
+		t.Error("admitted client did not enter the room")
+	}
+	if _, ok := state.clients[denied]; ok || len(state.lobby) != 0 {
+		t.Error("denied client is still tracked")
+	}
+	if got := kinds(received(t, denied)); strings.Join(got, ",") != "lobby:waiting,lobby:denied" {
+		t.Errorf("denied client received %v", got)
+	}
+	if _, ok := state.participants[3]; ok {
+		t.Error("denied client became a participant")
+	}
+}
+
+func TestRoomStateBanEvictsEveryDevice(t *testing.T) {
+	room := testRoom(domain.RoomSettings{}, Config{ResumeGrace: time.Minute})
+	state := newRoomState(room, zap.NewNop())
+
+	host := testClient(t, room, 1)
+	if err := register(state, host); err != nil {
+		t.Fatalf("register host: %v", err)
+	}
+
+	var devices []*Client
+	for range 2 {
+		guest := testClient(t, room, 2)
+		guest.guest = true
+		guest.fingerprint = "fingerprint"
+		if err := register(state, guest); err != nil {
+			t.Fatalf("register guest: %v", err)
+		}
+		devices = append(devices, guest)
+	}
+	// одно из устройств оборвалось и ждёт возврата
+	if state.unregister(devices[0]) {
+		t.Fatal("room reported empty")
+	}
+	received(t, host)
+
+	notice := moderationMessage{Type: "moderation", Action: domain.ModerationBan, Target: 2, By: 1}
+	if state.moderate(moderationRequest{sender: host, notice: notice}) {
+		t.Fatal("room reported empty while the host is connected")
+	}
+
+	if len(state.clients) != 1 || len(state.suspended) != 0 || state.present(2) {
+		t.Errorf("banned user still present: %d clients, %d suspended", len(state.clients), len(state.suspended))
+	}
+	if !room.IsBanned(2, true, "") || !room.IsBanned(99, true, "fingerprint") {
+		t.Error("ban did not cover the user id and guest fingerprint")
+	}
+
+	var left bool
+	for _, f := range received(t, host) {
+		if f.String() == "presence:leave:user" && f.UserID == 2 {
+			left = true
+		}
+	}
+	if !left {
+		t.Error("host was not told the banned user left")
+	}
+}
+
+func TestRoomStateRemotePresence(t *testing.T) {
+	room := testRoom(domain.RoomSettings{}, Config{})
+	state := newRoomState(room, zap.NewNop())
+
+	host := testClient(t, room, 1)
+	if err := register(state, host); err != nil {
+		t.Fatalf("register host: %v", err)
+	}
+	received(t, host)
+
+	state.onPresence("node-b", presenceData("join", presenceDevice, 2, "remote-1", "Bob"))
+	if got := kinds(received(t, host)); strings.Join(got, ",") != "presence:join:user,presence:join:device" {
+		t.Errorf("remote join: host received %v", got)
+	}
+	if got := state.occupied(); got != 2 {
+		t.Errorf("occupied = %d, want 2", got)
+	}
+	if !state.remoteDevice("remote-1") {
+		t.Error("remote device is not routable")
+	}
+
+	// уход чужого соединения, о котором нода не знала, не рассылается
+	state.onPresence("node-b", presenceData("leave", presenceDevice, 2, "unknown", ""))
+	if got := received(t, host); len(got) != 0 {
+		t.Errorf("unknown leave: host received %v", kinds(got))
+	}
+
+	state.onPresence("node-b", presenceData("leave", presenceDevice, 2, "remote-1", ""))
+	if got := kinds(received(t, host)); strings.Join(got, ",") != "presence:leave:device,presence:leave:user" {
+		t.Errorf("remote leave: host received %v", got)
+	}
+	if state.present(2) || state.occupied() != 1 {
+		t.Error("remote participant was not removed")
+	}
+}
+
+// rosterBroker отдаёт заданный список участников, как RedisBroker, который уже
+// отбросил записи нод с истёкшим heartbeat.
+type rosterBroker struct {
+	MemoryBroker
+	participants []Participant
+}
+
+func (b *rosterBroker) Participants(ctx context.Context, roomID string) ([]Participant, error) {
+	return b.participants, nil
+}
+
+func TestRoomStateDropsDevicesOfDeadNode(t *testing.T) {
+	alive := Participant{ID: 2, ClientID: "b-1", Node: "node-b"}
+	broker := &rosterBroker{participants: []Participant{alive}}
+	room := newRoom(&domain.Room{ID: "room", OwnerID: 1}, "node-a", broker, nil, nil, Config{}, nil, zap.NewNop())
+	state := newRoomState(room, zap.NewNop())
+
+	host := testClient(t, room, 1)
+	if err := register(state, host); err != nil {
+		t.Fatalf("register host: %v", err)
+	}
+	// node-c упала, не успев разослать presence leave
+	state.addRemote([]Participant{
+		alive,
+		{ID: 2, ClientID: "c-1", Node: "node-c"},
+		{ID: 3, ClientID: "c-2", Node: "node-c"},
+	})
+	received(t, host)
+
+	since := time.Now()
+	// это устройство появилось после запроса к брокеру, и список о нём ещё не знает
+	state.onPresence("node-c", presenceData("join", presenceDevice, 4, "c-3", ""))
+	received(t, host)
+
+	go room.fetchRoster(since)
+	update := <-room.roster
+	if update.err != nil {
+		t.Fatalf("fetch roster: %v", update.err)
+	}
+	state.reconcile(update.participants, update.since)
+
+	if state.remoteDevice("c-1") || state.remoteDevice("c-2") {
+		t.Error("devices of the dead node are still tracked")
+	}
+	if !state.remoteDevice("b-1") || !state.remoteDevice("c-3") {
+		t.Error("reconcile dropped a device it could not have seen in the roster")
+	}
+	if state.present(3) {
+		t.Error("user of the dead node is still present")
+	}
+	if got := state.occupied(); got != 3 {
+		t.Errorf("occupied = %d, want 3", got)
+	}
+
+	leaves := make(map[string]int)
+	for _, f := range received(t, host) {
+		if f.Action == "leave" {
+			leaves[f.Scope]++
+		}
+	}
+	if leaves[presenceDevice] != 2 || leaves[presenceUser] != 1 {
+		t.Errorf("host received leaves %v, want two devices and one user", leaves)
+	}
+}
+
+// stalledBroker не отвечает, пока тест не закроет release.
+type stalledBroker struct {
+	MemoryBroker
+	release chan struct{}
+
+	mu  sync.Mutex
+	ops []string
+}
+
+func (b *stalledBroker) wait(op string) {
+	<-b.release
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ops = append(b.ops, op)
+}
+
+func (b *stalledBroker) Publish(ctx context.Context, roomID string, event Event) error {
+	b.wait("publish")
+	return nil
+}
+
+func (b *stalledBroker) Join(ctx context.Context, roomID string, participant Participant) error {
+	b.wait("join")
+	return nil
+}
+
+func (b *stalledBroker) Leave(ctx context.Context, roomID, node, clientID string) error {
+	b.wait("leave")
+	return nil
+}
+
+func TestRoomStateDoesNotWaitForBroker(t *testing.T) {
+	broker := &stalledBroker{release: make(chan struct{})}
+	room := newRoom(&domain.Room{ID: "room", OwnerID: 1}, "node-a", broker, nil, nil, Config{}, nil, zap.NewNop())
+	go room.brokerLoop()
+	state := newRoomState(room, zap.NewNop())
+
+	clients := []*Client{testClient(t, room, 1), testClient(t, room, 2), testClient(t, room, 3)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, client := range clients {
+			if err := register(state, client); err != nil {
+				t.Errorf("register: %v", err)
+			}
+		}
+		state.closeAll(func(*Client) outbound {
+			return priorityOutbound(systemMessage{Type: "system", Event: "room_closed"})
+		}, websocket.StatusNormalClosure, "room closed")
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("room loop is blocked by the broker")
+	}
+
+	// так комнату закрывает run: оставшиеся записи ещё должны дойти до брокера
+	close(room.brokerOps)
+	close(broker.release)
+	<-room.published
+
+	counts := make(map[string]int)
+	for _, op := range broker.ops {
+		counts[op]++
+	}
+	if counts["join"] != 3 || counts["leave"] != 3 || counts["publish"] != 6 {
+		t.Errorf("broker received %v, want 3 joins, 3 leaves and 6 events", counts)
+	}
+	if broker.ops[0] != "join" || broker.ops[len(broker.ops)-1] != "publish" {
+		t.Errorf("broker operations out of order: %v", broker.ops)
+	}
+}
+
+type historyStore struct {
+	calls    atomic.Int32
+	messages []domain.Message
+}
+
+func (s *historyStore) PostMessage(ctx context.Context, roomID string, userID uint64, displayName, text string) (*domain.Message, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *historyStore) History(ctx context.Context, roomID string, before uint64, limit int) ([]domain.Message, uint64, error) {
+	s.calls.Add(1)
+	return s.messages, 0, nil
+}
+
+func TestRoomStateLoadsHistoryOnlyForAdmittedClients(t *testing.T) {
+	store := &historyStore{messages: []domain.Message{{ID: 7, UserID: 2, Text: "hello"}}}
+	cfg := Config{HistorySize: 10, ResumeGrace: time.Minute}
+	room := newRoom(&domain.Room{ID: "room", OwnerID: 1, Settings: domain.RoomSettings{MaxParticipants: 1}}, "node-a", NewMemoryBroker(), store, nil, cfg, nil, zap.NewNop())
+	state := newRoomState(room, zap.NewNop())
+
+	host := testClient(t, room, 1)
+	if err := register(state, host); err != nil {
+		t.Fatalf("register host: %v", err)
+	}
+	select {
+	case reply := <-room.reply:
+		var history historyMessage
+		if err := json.Unmarshal(reply.item.data, &history); err != nil || reply.client != host || len(history.Messages) != 1 || history.Messages[0].ID != 7 {
+			t.Errorf("history reply = %s", reply.item.data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("history was not sent")
+	}
+
+	var full *RoomFullError
+	if err := register(state, testClient(t, room, 2)); !errors.As(err, &full) {
+		t.Fatalf("register over capacity: error = %v", err)
+	}
+
+	// возврат в свой слот после обрыва: история у клиента уже есть
+	state.unregister(host)
+	resumed := testClient(t, room, 1)
+	resumed.resumeToken = host.resumeToken
+	if err := register(state, resumed); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+
+	if got := store.calls.Load(); got != 1 {
+		t.Errorf("history loaded %d times, want 1", got)
+	}
+}
@@ -0,0 +1,175 @@
+package signaling
+
+import (
+	"chatter/internal/domain"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// expect ждёт, пока клиент получит сообщение want, и возвращает его вместе с
+// пропущенными по дороге.
+func expect(t *testing.T, client *Client, want string) (frame, []frame) {
+	t.Helper()
+
+	var skipped []frame
+	timeout := time.After(time.Second)
+	for {
+		item, ok := client.out.pop()
+		if !ok {
+			select {
+			case <-client.out.ready:
+				continue
+			case <-timeout:
+				t.Fatalf("client %d did not receive %s, got %v", client.ID(), want, kinds(skipped))
+			}
+		}
+
+		var f frame
+		if err := json.Unmarshal(item.bytes(), &f); err != nil {
+			t.Fatalf("unmarshal %s: %v", item.bytes(), err)
+		}
+		if f.String() == want {
+			return f, skipped
+		}
+		skipped = append(skipped, f)
+	}
+}
+
+// welcome ждёт сообщение welcome, пропуская всё, что пришло до него.
+func welcome(t *testing.T, client *Client) welcomeMessage {
+	t.Helper()
+
+	timeout := time.After(time.Second)
+	for {
+		item, ok := client.out.pop()
+		if !ok {
+			select {
+			case <-client.out.ready:
+				continue
+			case <-timeout:
+				t.Fatalf("client %d did not receive welcome", client.ID())
+			}
+		}
+
+		var msg welcomeMessage
+		if err := json.Unmarshal(item.bytes(), &msg); err == nil && msg.Type == "welcome" {
+			return msg
+		}
+	}
+}
+
+func runningRoom(t *testing.T, cfg Config) *Room {
+	t.Helper()
+
+	room := NewRoom(&domain.Room{ID: "room", OwnerID: 1}, "node-a", NewMemoryBroker(), nil, nil, cfg, nil, zap.NewNop())
+	t.Cleanup(func() { room.Close("test finished") })
+
+	return room
+}
+
+func join(t *testing.T, room *Room, userID uint64) (*Client, welcomeMessage) {
+	t.Helper()
+
+	client := testClient(t, room, userID)
+	if err := room.Register(client); err != nil {
+		t.Fatalf("register %d: %v", userID, err)
+	}
+
+	return client, welcome(t, client)
+}
+
+func TestRoomResumeKeepsSlotAndReplaysMissedMessages(t *testing.T) {
+	room := runningRoom(t, Config{ResumeGrace: time.Minute, ResumeBuffer: 16})
+	host, _ := join(t, room, 1)
+	guest, greeting := join(t, room, 2)
+	expect(t, host, "presence:join:device")
+
+	// соединение оборвалось, а не закрылось клиентом
+	room.Unregister(guest)
+	room.Broadcast(host, "chat", mustMarshal(chatMessage{Type: "chat", Text: "missed"}))
+
+	again := testClient(t, room, 2)
+	again.resumeToken = greeting.ResumeToken
+	if err := room.Register(again); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+
+	resumed := welcome(t, again)
+	if !resumed.Resumed || resumed.ClientID != greeting.ClientID {
+		t.Errorf("welcome after resume = %+v, want the old connection id %s", resumed, greeting.ClientID)
+	}
+	if resumed.ResumeToken == "" || resumed.ResumeToken == greeting.ResumeToken {
+		t.Error("resume token was not rotated")
+	}
+	expect(t, again, "chat")
+
+	// собеседники обрыва не заметили
+	room.Broadcast(again, "chat", mustMarshal(chatMessage{Type: "chat", Text: "back"}))
+	if _, skipped := expect(t, host, "chat"); len(skipped) != 0 {
+		t.Errorf("host received %v before the next message", kinds(skipped))
+	}
+}
+
+func TestRoomResumeSlotExpires(t *testing.T) {
+	room := runningRoom(t, Config{ResumeGrace: 20 * time.Millisecond})
+	host, _ := join(t, room, 1)
+	guest, greeting := join(t, room, 2)
+	expect(t, host, "presence:join:device")
+
+	room.Unregister(guest)
+	expect(t, host, "presence:leave:device")
+	expect(t, host, "presence:leave:user")
+
+	late := testClient(t, room, 2)
+	late.resumeToken = greeting.ResumeToken
+	if err := room.Register(late); err != nil {
+		t.Fatalf("register after expiry: %v", err)
+	}
+
+	fresh := welcome(t, late)
+	if fresh.Resumed || fresh.ClientID == greeting.ClientID {
+		t.Errorf("welcome after expiry = %+v, want a new connection", fresh)
+	}
+	expect(t, host, "presence:join:user")
+}
+
+func TestRoomLeavingClientIsNotHeld(t *testing.T) {
+	room := runningRoom(t, Config{ResumeGrace: time.Minute})
+	host, _ := join(t, room, 1)
+	guest, greeting := join(t, room, 2)
+	expect(t, host, "presence:join:device")
+
+	// клиент закрыл соединение сам: место не держим и сразу сообщаем об уходе
+	guest.leaving = true
+	room.Unregister(guest)
+	expect(t, host, "presence:leave:device")
+	expect(t, host, "presence:leave:user")
+
+	again := testClient(t, room, 2)
+	again.resumeToken = greeting.ResumeToken
+	if err := room.Register(again); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if msg := welcome(t, again); msg.Resumed {
+		t.Error("slot of a client that left was resumed")
+	}
+}
+
+func TestRoomResumeTokenIsBoundToUser(t *testing.T) {
+	room := runningRoom(t, Config{ResumeGrace: time.Minute})
+	join(t, room, 1)
+	guest, greeting := join(t, room, 2)
+	room.Unregister(guest)
+
+	other := testClient(t, room, 3)
+	other.resumeToken = greeting.ResumeToken
+	if err := room.Register(other); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if msg := welcome(t, other); msg.Resumed || msg.ClientID == greeting.ClientID {
+		t.Errorf("another user took over the slot: %+v", msg)
+	}
+}
@@ -0,0 +1,145 @@
+package signaling
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// outboundTypes - сообщения, которые сервер отправляет клиентам; нужны только для схемы.
+var outboundTypes = []struct {
+	kind        string
+	description string
+	payload     any
+}{
+	{"welcome", "First message after joining: connection id, user id and negotiated protocol version.", welcomeMessage{}},
+	{"participants", "Full participant list with their connected devices.", participantsMessage{}},
+	{"presence", "A user or one of their devices joined or left the room.", presenceMessage{}},
+	{"profile", "A participant changed their display name.", profileMessage{}},
+	{"chat", "A chat message, stamped with the sender known to the server.", chatMessage{}},
+	{"history", "Recent chat messages, sent once after joining.", historyMessage{}},
+	{"webrtc", "An offer, answer or ICE candidates relayed from another connection.", webrtcRelay{}},
+	{"error", "A message was rejected or an operation failed.", errorMessage{}},
+	{"moderation", "A participant was kicked, banned or muted.", moderationMessage{}},
+	{"lobby", "Lobby status for a waiting client.", lobbyMessage{}},
+	{"lobby_request", "A client is waiting in the lobby. Sent to the owner and moderators.", lobbyRequestMessage{}},
+	{"system", "Server notice: announcement, room closed or disconnect.", systemMessage{}},
+	{"server_shutdown", "The node is shutting down; reconnect after the given delay.", shutdownMessage{}},
+}
+
+var rawMessageType = reflect.TypeFor[json.RawMessage]()
+
+// ProtocolSchema собирает JSON Schema протокола сигнализации из структур сообщений.
+func ProtocolSchema() ([]byte, error) {
+	defs := map[string]any{}
+	var client, server []any
+
+	kinds := make([]string, 0, len(inboundTypes))
+	for kind := range inboundTypes {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+
+	for _, kind := range kinds {
+		spec := inboundTypes[kind]
+		name := "client." + kind
+		defs[name] = messageSchema(kind, spec.description, spec.payload)
+		client = append(client, map[string]any{"$ref": "#/$defs/" + name})
+	}
+
+	for _, out := range outboundTypes {
+		name := "server." + out.kind
+		defs[name] = messageSchema(out.kind, out.description, reflect.TypeOf(out.payload))
+		server = append(server, map[string]any{"$ref": "#/$defs/" + name})
+	}
+
+	defs["clientMessage"] = map[string]any{"oneOf": client}
+	defs["serverMessage"] = map[string]any{"oneOf": server}
+
+	return json.MarshalIndent(map[string]any{
+		"$schema":         "https://json-schema.org/draft/2020-12/schema",
+		"title":           "chatter signaling protocol",
+		"protocolVersion": ProtocolVersion,
+		"subprotocols":    subprotocols,
+		"$defs":           defs,
+	}, "", "  ")
+}
+
+func messageSchema(kind, description string, payload reflect.Type) map[string]any {
+	schema := typeSchema(payload)
+	schema["description"] = description
+
+	properties := schema["properties"].(map[string]any)
+	properties["type"] = map[string]any{"const": kind}
+	if required, _ := schema["required"].([]string); !slices.Contains(required, "type") {
+		schema["required"] = append([]string{"type"}, required...)
+	}
+
+	return schema
+}
+
+func typeSchema(t reflect.Type) map[string]any {
+	if t == rawMessageType {
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]any{}
+		required := []string{}
+		addFields(t, properties, &required)
+		return map[string]any{"type": "object", "properties": properties, "required": required}
+	default:
+		return map[string]any{}
+	}
+}
+
+// addFields раскладывает поля структуры по правилам encoding/json, включая встроенные структуры.
+func addFields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			addFields(field.Type, properties, required)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema := typeSchema(field.Type)
+		if enum := field.Tag.Get("enum"); enum != "" {
+			schema["enum"] = strings.Split(enum, ",")
+		}
+		properties[name] = schema
+
+		if !slices.Contains(strings.Split(options, ","), "omitempty") && !slices.Contains(*required, name) {
+			*required = append(*required, name)
+		}
+	}
+}
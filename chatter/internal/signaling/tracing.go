@@ -0,0 +1,63 @@
+package signaling
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceparentField - поле конверта с W3C trace context. Клиент копирует его из
+// полученного offer в answer и ice, и весь обмен попадает в один трейс.
+const traceparentField = "traceparent"
+
+var tracer = otel.Tracer("chatter/signaling")
+
+func startSession(ctx context.Context, roomID string, client *Client) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "signaling.session",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("room.id", roomID),
+			attribute.Int64("user.id", int64(client.ID())),
+			attribute.Bool("user.guest", client.guest),
+			attribute.String("websocket.subprotocol", client.conn.Subprotocol()),
+		),
+	)
+}
+
+// startMessageSpan открывает спан обработки входящего сообщения. Если в конверте
+// есть traceparent, спан продолжает трейс собеседника, а с сессией связан ссылкой.
+func startMessageSpan(sender *Client, roomID, kind, traceparent string) (context.Context, trace.Span) {
+	ctx := sender.session
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("room.id", roomID),
+			attribute.Int64("user.id", int64(sender.ID())),
+			attribute.String("client.id", sender.ClientID()),
+			attribute.String("signaling.type", metricType(kind)),
+		),
+	}
+
+	if traceparent != "" {
+		remote := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier{traceparentField: traceparent})
+		if trace.SpanContextFromContext(remote).IsValid() {
+			opts = append(opts, trace.WithLinks(trace.LinkFromContext(ctx)))
+			ctx = remote
+		}
+	}
+
+	return tracer.Start(ctx, "signaling.message "+metricType(kind), opts...)
+}
+
+func traceparent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier.Get(traceparentField)
+}
@@ -37,7 +37,7 @@ type RefreshTokenRepository interface {
 }
 
 type TokenManager interface {
-	GenerateAccessToken(userID uint64, username string) (string, error)
+	GenerateAccessToken(userID uint64, username, role string) (string, error)
 	GenerateRefreshToken() (string, error)
 	ParseAccessToken(token string) (string, uint64, error)
 }
@@ -78,7 +78,7 @@ func (s *AuthService) Register(ctx context.Context, username, password, deviceID
 		return nil, "", "", ErrUserExists
 	}
 
-	accessToken, refreshToken, err := s.generateTokens(ctx, user.ID, username, deviceID)
+	accessToken, refreshToken, err := s.generateTokens(ctx, user.ID, username, user.Role, deviceID)
 	if err != nil {
 		s.logger.Error("Failed to generate tokens", zap.Uint64("userID", user.ID), zap.Error(err))
 		return nil, "", "", fmt.Errorf("failed to generate tokens: %w", err)
@@ -99,7 +99,7 @@ func (s *AuthService) Login(ctx context.Context, username, password, deviceID st
 		return "", "", nil, ErrInvalidCreds
 	}
 
-	accessToken, refreshToken, err := s.generateTokens(ctx, user.ID, user.Username, deviceID)
+	accessToken, refreshToken, err := s.generateTokens(ctx, user.ID, user.Username, user.Role, deviceID)
 	if err != nil {
 		s.logger.Error("Failed to generate tokens", zap.Uint64("userID", user.ID), zap.Error(err))
 		return "", "", nil, fmt.Errorf("failed to generate tokens: %w", err)
@@ -133,7 +133,7 @@ func (s *AuthService) RefreshTokens(ctx context.Context, refreshToken string) (s
 		return "", "", nil, ErrInvalidToken
 	}
 
-	accessToken, newRefreshToken, err := s.generateTokens(ctx, user.ID, user.Username, token.DeviceID)
+	accessToken, newRefreshToken, err := s.generateTokens(ctx, user.ID, user.Username, user.Role, token.DeviceID)
 	if err != nil {
 		return "", "", nil, fmt.Errorf("failed to generate tokens: %w", err)
 	}
@@ -141,6 +141,21 @@ func (s *AuthService) RefreshTokens(ctx context.Context, refreshToken string) (s
 	return accessToken, newRefreshToken, user, nil
 }
 
+// AuthenticateSession узнаёт пользователя по refresh-токену из куки, не ротируя его.
+func (s *AuthService) AuthenticateSession(ctx context.Context, refreshToken string) (*domain.User, error) {
+	token, err := s.tokenStore.GetRefreshTokenByHash(ctx, hashToken(refreshToken))
+	if err != nil || token.Revoked || time.Now().After(token.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+
+	user, ok := s.authStore.GetUserByID(ctx, token.UserID)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	return user, nil
+}
+
 func (s *AuthService) ListActiveSessions(ctx context.Context, userID uint64) ([]domain.RefreshToken, error) {
 	if userID == 0 {
 		return nil, ErrInvalidToken
@@ -149,14 +164,14 @@ func (s *AuthService) ListActiveSessions(ctx context.Context, userID uint64) ([]
 	return s.tokenStore.ListActiveSessions(ctx, userID)
 }
 
-func (s *AuthService) generateTokens(ctx context.Context, userID uint64, username, deviceID string) (string, string, error) {
+func (s *AuthService) generateTokens(ctx context.Context, userID uint64, username, role, deviceID string) (string, string, error) {
 	if deviceID != "" {
 		if err := s.tokenStore.RevokeUserDeviceTokens(ctx, userID, deviceID); err != nil {
 			s.logger.Warn("Failed to revoke old device tokens", zap.Error(err))
 		}
 	}
 
-	accessToken, err := s.tokens.GenerateAccessToken(userID, username)
+	accessToken, err := s.tokens.GenerateAccessToken(userID, username, role)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to generate access token: %w", err)
 	}
@@ -0,0 +1,86 @@
+package usecase
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"chatter/internal/domain"
+
+	"go.uber.org/zap"
+)
+
+type GuestTokenManager interface {
+	GenerateGuestToken(guestID uint64, displayName string, ttl time.Duration) (string, error)
+	ParseGuestToken(token string) (uint64, string, error)
+}
+
+type GuestService struct {
+	tokens   GuestTokenManager
+	guestTTL time.Duration
+	logger   *zap.Logger
+}
+
+func NewGuestService(tokens GuestTokenManager, guestTTL time.Duration, logger *zap.Logger) *GuestService {
+	return &GuestService{
+		tokens:   tokens,
+		guestTTL: guestTTL,
+		logger:   logger,
+	}
+}
+
+// Identify восстанавливает гостя по токену или выдаёт новую личность.
+// Токен каждый раз перевыпускается, чтобы срок жизни отсчитывался от последнего входа.
+func (s *GuestService) Identify(token string) (*domain.Guest, string, error) {
+	guest := &domain.Guest{}
+	if token != "" {
+		id, name, err := s.tokens.ParseGuestToken(token)
+		if err == nil && domain.IsGuestID(id) {
+			guest.ID = id
+			guest.DisplayName = name
+		}
+	}
+
+	if guest.ID == 0 {
+		id, err := generateGuestID()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to generate guest id: %w", err)
+		}
+		guest.ID = id
+	}
+
+	if guest.DisplayName == "" {
+		name, err := generateGuestName()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to generate guest name: %w", err)
+		}
+		guest.DisplayName = name
+	}
+
+	signed, err := s.tokens.GenerateGuestToken(guest.ID, guest.DisplayName, s.guestTTL)
+	if err != nil {
+		s.logger.Error("Failed to sign guest token", zap.Uint64("guestID", guest.ID), zap.Error(err))
+		return nil, "", fmt.Errorf("failed to sign guest token: %w", err)
+	}
+
+	return guest, signed, nil
+}
+
+func generateGuestID() (uint64, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return 0, err
+	}
+
+	return domain.GuestIDMin + binary.BigEndian.Uint64(buf)%(domain.GuestIDMax-domain.GuestIDMin+1), nil
+}
+
+func generateGuestName() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
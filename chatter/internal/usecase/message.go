@@ -0,0 +1,84 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"chatter/internal/domain"
+
+	"go.uber.org/zap"
+)
+
+const (
+	MaxMessageLength   = 4000
+	defaultHistorySize = 50
+	maxHistorySize     = 200
+)
+
+var ErrInvalidMessage = errors.New("invalid message")
+
+type MessageRepository interface {
+	CreateMessage(ctx context.Context, message *domain.Message) (*domain.Message, error)
+	ListMessages(ctx context.Context, roomID string, before uint64, limit int) ([]domain.Message, error)
+}
+
+type MessageService struct {
+	messageStore MessageRepository
+	logger       *zap.Logger
+}
+
+func NewMessageService(messageStore MessageRepository, logger *zap.Logger) *MessageService {
+	return &MessageService{
+		messageStore: messageStore,
+		logger:       logger,
+	}
+}
+
+func (s *MessageService) PostMessage(ctx context.Context, roomID string, userID uint64, displayName, text string) (*domain.Message, error) {
+	text = strings.TrimSpace(text)
+	if text == "" || utf8.RuneCountInString(text) > MaxMessageLength {
+		return nil, ErrInvalidMessage
+	}
+
+	message, err := s.messageStore.CreateMessage(ctx, &domain.Message{
+		RoomID:      roomID,
+		UserID:      userID,
+		DisplayName: displayName,
+		Text:        text,
+	})
+	if err != nil {
+		s.logger.Error("Failed to store message", zap.String("roomID", roomID), zap.Error(err))
+		return nil, fmt.Errorf("failed to store message: %w", err)
+	}
+
+	return message, nil
+}
+
+// History возвращает страницу сообщений в хронологическом порядке и курсор
+// для следующей (более старой) страницы; нулевой курсор означает, что страниц больше нет.
+func (s *MessageService) History(ctx context.Context, roomID string, before uint64, limit int) ([]domain.Message, uint64, error) {
+	if limit <= 0 {
+		limit = defaultHistorySize
+	}
+	if limit > maxHistorySize {
+		limit = maxHistorySize
+	}
+
+	messages, err := s.messageStore.ListMessages(ctx, roomID, before, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list messages: %w", err)
+	}
+
+	var next uint64
+	if len(messages) == limit {
+		next = messages[len(messages)-1].ID
+	}
+
+	slices.Reverse(messages)
+
+	return messages, next, nil
+}
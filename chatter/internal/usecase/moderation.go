@@ -0,0 +1,40 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+
+	"chatter/internal/domain"
+
+	"go.uber.org/zap"
+)
+
+type ModerationRepository interface {
+	CreateAuditRecord(ctx context.Context, action *domain.ModerationAction) error
+}
+
+type ModerationService struct {
+	auditStore ModerationRepository
+	logger     *zap.Logger
+}
+
+func NewModerationService(auditStore ModerationRepository, logger *zap.Logger) *ModerationService {
+	return &ModerationService{
+		auditStore: auditStore,
+		logger:     logger,
+	}
+}
+
+func (s *ModerationService) RecordAction(ctx context.Context, action *domain.ModerationAction) error {
+	if err := s.auditStore.CreateAuditRecord(ctx, action); err != nil {
+		s.logger.Error("Failed to record moderation action",
+			zap.String("roomID", action.RoomID),
+			zap.String("action", action.Action),
+			zap.Uint64("actorID", action.ActorID),
+			zap.Error(err),
+		)
+		return fmt.Errorf("failed to record moderation action: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,206 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"chatter/internal/domain"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	maxRoomTitleLength = 128
+	minPasscodeLength  = 8
+)
+
+var (
+	ErrRoomNotFound     = domain.ErrRoomNotFound
+	ErrInvalidTitle     = errors.New("invalid room title")
+	ErrInvalidAccess    = errors.New("invalid room access policy")
+	ErrInvalidCapacity  = errors.New("invalid room capacity")
+	ErrInvalidPasscode  = errors.New("invalid passcode")
+	ErrPasscodeRequired = errors.New("passcode required")
+	ErrInviteRequired   = errors.New("invite required")
+	ErrInvalidInvite    = errors.New("invalid invite")
+	ErrForbidden        = errors.New("forbidden")
+	ErrGuestsDisabled   = errors.New("guests are not allowed")
+)
+
+type RoomRepository interface {
+	CreateRoom(ctx context.Context, room *domain.Room) (*domain.Room, error)
+	// GetRoomByID и GetRoomBySlug возвращают domain.ErrRoomNotFound, если комнаты нет
+	GetRoomByID(ctx context.Context, id string) (*domain.Room, error)
+	GetRoomBySlug(ctx context.Context, slug string) (*domain.Room, error)
+	ListRoomsByOwner(ctx context.Context, ownerID uint64) ([]domain.Room, error)
+}
+
+type InviteManager interface {
+	GenerateInviteToken(roomID string, ttl time.Duration) (string, error)
+	ParseInviteToken(token string) (string, error)
+}
+
+type RoomService struct {
+	roomStore RoomRepository
+	invites   InviteManager
+	inviteTTL time.Duration
+	logger    *zap.Logger
+}
+
+func NewRoomService(roomStore RoomRepository, invites InviteManager, inviteTTL time.Duration, logger *zap.Logger) *RoomService {
+	return &RoomService{
+		roomStore: roomStore,
+		invites:   invites,
+		inviteTTL: inviteTTL,
+		logger:    logger,
+	}
+}
+
+func (s *RoomService) CreateRoom(ctx context.Context, ownerID uint64, title, passcode, slug string, randomSlug bool, settings domain.RoomSettings) (*domain.Room, error) {
+	title = strings.TrimSpace(title)
+	if utf8.RuneCountInString(title) > maxRoomTitleLength {
+		return nil, ErrInvalidTitle
+	}
+
+	if settings.MaxParticipants < 0 {
+		return nil, ErrInvalidCapacity
+	}
+
+	var passcodeHash []byte
+	switch settings.Access {
+	case "", domain.RoomAccessPublic:
+		settings.Access = domain.RoomAccessPublic
+	case domain.RoomAccessPasscode:
+		if utf8.RuneCountInString(passcode) < minPasscodeLength {
+			return nil, ErrInvalidPasscode
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(passcode), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash passcode: %w", err)
+		}
+		passcodeHash = hash
+	case domain.RoomAccessInvite:
+	default:
+		return nil, ErrInvalidAccess
+	}
+
+	slug, err := s.pickSlug(ctx, slug, randomSlug)
+	if err != nil {
+		return nil, err
+	}
+
+	roomID, err := generateRoomID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate room id: %w", err)
+	}
+
+	room, err := s.roomStore.CreateRoom(ctx, &domain.Room{
+		ID:           roomID,
+		Slug:         slug,
+		OwnerID:      ownerID,
+		Title:        title,
+		Settings:     settings,
+		PasscodeHash: passcodeHash,
+	})
+	if err != nil {
+		// имя могли занять между проверкой и вставкой
+		if slug != "" {
+			if taken, _ := s.slugTaken(ctx, slug); taken {
+				return nil, ErrSlugTaken
+			}
+		}
+		s.logger.Error("Failed to create room", zap.Uint64("ownerID", ownerID), zap.Error(err))
+		return nil, fmt.Errorf("failed to create room: %w", err)
+	}
+
+	return room, nil
+}
+
+func (s *RoomService) GetRoom(ctx context.Context, roomID string) (*domain.Room, error) {
+	room, err := s.roomStore.GetRoomByID(ctx, roomID)
+	if errors.Is(err, ErrRoomNotFound) {
+		// вместо id можно указать имя комнаты
+		room, err = s.roomStore.GetRoomBySlug(ctx, roomID)
+	}
+	if err != nil {
+		if errors.Is(err, ErrRoomNotFound) {
+			return nil, ErrRoomNotFound
+		}
+		return nil, fmt.Errorf("failed to get room: %w", err)
+	}
+
+	return room, nil
+}
+
+func (s *RoomService) ListOwnedRooms(ctx context.Context, ownerID uint64) ([]domain.Room, error) {
+	rooms, err := s.roomStore.ListRoomsByOwner(ctx, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rooms: %w", err)
+	}
+
+	return rooms, nil
+}
+
+func (s *RoomService) CreateInvite(ctx context.Context, roomID string, userID uint64) (string, time.Time, error) {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	if room.OwnerID != userID {
+		return "", time.Time{}, ErrForbidden
+	}
+
+	token, err := s.invites.GenerateInviteToken(room.ID, s.inviteTTL)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate invite: %w", err)
+	}
+
+	return token, time.Now().Add(s.inviteTTL), nil
+}
+
+func (s *RoomService) AuthorizeJoin(room *domain.Room, userID uint64, passcode, invite string) error {
+	if userID != 0 && room.OwnerID == userID {
+		return nil
+	}
+
+	if room.Settings.DisableGuests && (userID == 0 || domain.IsGuestID(userID)) {
+		return ErrGuestsDisabled
+	}
+
+	switch room.Settings.Access {
+	case domain.RoomAccessPasscode:
+		if passcode == "" {
+			return ErrPasscodeRequired
+		}
+		if err := bcrypt.CompareHashAndPassword(room.PasscodeHash, []byte(passcode)); err != nil {
+			return ErrInvalidPasscode
+		}
+	case domain.RoomAccessInvite:
+		if invite == "" {
+			return ErrInviteRequired
+		}
+		roomID, err := s.invites.ParseInviteToken(invite)
+		if err != nil || roomID != room.ID {
+			return ErrInvalidInvite
+		}
+	}
+
+	return nil
+}
+
+func generateRoomID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
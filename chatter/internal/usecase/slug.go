@@ -0,0 +1,114 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"strings"
+)
+
+const (
+	minSlugLength = 3
+	maxSlugLength = 40
+	slugAttempts  = 5
+)
+
+var (
+	ErrInvalidSlug = errors.New("invalid room name")
+	ErrSlugTaken   = errors.New("room name is already taken")
+)
+
+var (
+	slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	// roomIDPattern - так выглядят id из generateRoomID; имя не должно с ними путаться
+	roomIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+)
+
+var slugAdjectives = []string{
+	"amber", "bold", "brave", "bright", "calm", "clever", "cosy", "crisp",
+	"daring", "eager", "fair", "fancy", "gentle", "glad", "golden", "grand",
+	"happy", "honest", "jolly", "keen", "kind", "lively", "lucky", "merry",
+	"mighty", "misty", "noble", "polite", "proud", "quick", "quiet", "rapid",
+	"rosy", "shiny", "silent", "silver", "smart", "snowy", "steady", "sunny",
+	"swift", "tidy", "vivid", "warm", "wild", "wise", "witty", "young",
+}
+
+var slugAnimals = []string{
+	"badger", "beaver", "bison", "cobra", "condor", "crane", "dingo", "dolphin",
+	"eagle", "falcon", "ferret", "gecko", "heron", "hippo", "ibis", "jackal",
+	"koala", "lemur", "lion", "llama", "lynx", "marten", "moose", "narwhal",
+	"newt", "ocelot", "otter", "owl", "panda", "parrot", "pelican", "puffin",
+	"quail", "rabbit", "raven", "salmon", "seal", "sparrow", "tapir", "tiger",
+	"toucan", "turtle", "viper", "walrus", "weasel", "wombat", "yak", "zebra",
+}
+
+// pickSlug проверяет заказанное владельцем имя комнаты или, если просили,
+// подбирает свободное произносимое вида brave-otter-742.
+func (s *RoomService) pickSlug(ctx context.Context, requested string, generate bool) (string, error) {
+	if requested != "" {
+		slug := strings.ToLower(strings.TrimSpace(requested))
+		if !validSlug(slug) {
+			return "", ErrInvalidSlug
+		}
+		taken, err := s.slugTaken(ctx, slug)
+		if err != nil {
+			return "", err
+		}
+		if taken {
+			return "", ErrSlugTaken
+		}
+		return slug, nil
+	}
+
+	if !generate {
+		return "", nil
+	}
+
+	for range slugAttempts {
+		slug := generateSlug()
+		taken, err := s.slugTaken(ctx, slug)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return slug, nil
+		}
+	}
+
+	return "", fmt.Errorf("failed to find a free room name in %d attempts", slugAttempts)
+}
+
+// slugTaken - имя занято другой комнатой или совпадает с чьим-то id.
+func (s *RoomService) slugTaken(ctx context.Context, slug string) (bool, error) {
+	_, err := s.roomStore.GetRoomBySlug(ctx, slug)
+	if errors.Is(err, ErrRoomNotFound) {
+		_, err = s.roomStore.GetRoomByID(ctx, slug)
+	}
+
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrRoomNotFound):
+		return false, nil
+	default:
+		return false, fmt.Errorf("failed to check room name: %w", err)
+	}
+}
+
+func validSlug(slug string) bool {
+	if len(slug) < minSlugLength || len(slug) > maxSlugLength {
+		return false
+	}
+
+	return slugPattern.MatchString(slug) && !roomIDPattern.MatchString(slug)
+}
+
+func generateSlug() string {
+	return fmt.Sprintf("%s-%s-%d",
+		slugAdjectives[rand.N(len(slugAdjectives))],
+		slugAnimals[rand.N(len(slugAnimals))],
+		100+rand.N(900),
+	)
+}
@@ -0,0 +1,210 @@
+package usecase
+
+import (
+	"chatter/internal/domain"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// slugStore - RoomRepository, в котором есть только занятые имена и id.
+// Первые busy запросов по имени считаются занятыми, чтобы проверить перебор.
+type slugStore struct {
+	slugs   map[string]bool
+	ids     map[string]bool
+	busy    int
+	err     error
+	lookups []string
+}
+
+func (s *slugStore) CreateRoom(ctx context.Context, room *domain.Room) (*domain.Room, error) {
+	return room, nil
+}
+
+func (s *slugStore) GetRoomByID(ctx context.Context, id string) (*domain.Room, error) {
+	if s.ids[id] {
+		return &domain.Room{ID: id}, nil
+	}
+	return nil, ErrRoomNotFound
+}
+
+func (s *slugStore) GetRoomBySlug(ctx context.Context, slug string) (*domain.Room, error) {
+	s.lookups = append(s.lookups, slug)
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.slugs[slug] || len(s.lookups) <= s.busy {
+		return &domain.Room{Slug: slug}, nil
+	}
+	return nil, ErrRoomNotFound
+}
+
+func (s *slugStore) ListRoomsByOwner(ctx context.Context, ownerID uint64) ([]domain.Room, error) {
+	return nil, nil
+}
+
+func TestValidSlug(t *testing.T) {
+	tests := []struct {
+		slug string
+		want bool
+	}{
+		{"team-sync", true},
+		{"abc", true},
+		{"room-42", true},
+		{"a1-b2-c3", true},
+		{strings.Repeat("a", maxSlugLength), true},
+		{"ab", false},
+		{strings.Repeat("a", maxSlugLength+1), false},
+		{"", false},
+		{"Team-Sync", false},
+		{"-team", false},
+		{"team-", false},
+		{"team--sync", false},
+		{"team_sync", false},
+		{"team sync", false},
+		{"комната", false},
+		// имя не должно выглядеть как id комнаты
+		{"0123456789abcdef0123456789abcdef", false},
+		{"0123456789abcdef0123456789abcde", true},
+		{"0123456789abcdef0123456789abcdeg", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.slug, func(t *testing.T) {
+			if got := validSlug(tt.slug); got != tt.want {
+				t.Errorf("validSlug(%q) = %v, want %v", tt.slug, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateSlugIsValid(t *testing.T) {
+	for range 100 {
+		if slug := generateSlug(); !validSlug(slug) {
+			t.Fatalf("generated slug %q is not valid", slug)
+		}
+	}
+}
+
+func TestPickSlug(t *testing.T) {
+	storeErr := errors.New("connection refused")
+
+	tests := []struct {
+		name      string
+		store     *slugStore
+		requested string
+		generate  bool
+		want      string
+		wantErr   error
+		// lookups - сколько имён проверено в хранилище
+		lookups int
+	}{
+		{
+			name:      "requested slug is normalized",
+			store:     &slugStore{},
+			requested: "  Team-Sync ",
+			want:      "team-sync",
+			lookups:   1,
+		},
+		{
+			name:      "invalid slug is not looked up",
+			store:     &slugStore{},
+			requested: "team_sync",
+			wantErr:   ErrInvalidSlug,
+		},
+		{
+			name:      "slug that looks like an id",
+			store:     &slugStore{},
+			requested: "0123456789abcdef0123456789abcdef",
+			wantErr:   ErrInvalidSlug,
+		},
+		{
+			name:      "slug taken by another room",
+			store:     &slugStore{slugs: map[string]bool{"team-sync": true}},
+			requested: "team-sync",
+			wantErr:   ErrSlugTaken,
+			lookups:   1,
+		},
+		{
+			name:      "slug equal to a room id",
+			store:     &slugStore{ids: map[string]bool{"legacy-room": true}},
+			requested: "legacy-room",
+			wantErr:   ErrSlugTaken,
+			lookups:   1,
+		},
+		{
+			name:      "store error",
+			store:     &slugStore{err: storeErr},
+			requested: "team-sync",
+			wantErr:   storeErr,
+			lookups:   1,
+		},
+		{
+			name:  "no slug requested or generated",
+			store: &slugStore{},
+		},
+		{
+			name:     "generated slug",
+			store:    &slugStore{},
+			generate: true,
+			lookups:  1,
+		},
+		{
+			name:     "generation retries collisions",
+			store:    &slugStore{busy: slugAttempts - 1},
+			generate: true,
+			lookups:  slugAttempts,
+		},
+		{
+			name:     "generation gives up",
+			store:    &slugStore{busy: slugAttempts},
+			generate: true,
+			wantErr:  errAnyError,
+			lookups:  slugAttempts,
+		},
+		{
+			name:      "requested slug wins over generation",
+			store:     &slugStore{},
+			requested: "team-sync",
+			generate:  true,
+			want:      "team-sync",
+			lookups:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewRoomService(tt.store, nil, 0, zap.NewNop())
+
+			got, err := service.pickSlug(context.Background(), tt.requested, tt.generate)
+			switch {
+			case tt.wantErr == errAnyError:
+				if err == nil {
+					t.Fatalf("pickSlug = %q, want an error", got)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("pickSlug error = %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("pickSlug: %v", err)
+			case tt.generate && tt.requested == "":
+				if !validSlug(got) {
+					t.Errorf("generated slug %q is not valid", got)
+				}
+			case got != tt.want:
+				t.Errorf("pickSlug = %q, want %q", got, tt.want)
+			}
+
+			if len(tt.store.lookups) != tt.lookups {
+				t.Errorf("looked up %d slugs (%v), want %d", len(tt.store.lookups), tt.store.lookups, tt.lookups)
+			}
+		})
+	}
+}
+
+// errAnyError - в таблице означает «какая-нибудь ошибка».
+var errAnyError = errors.New("any error")
@@ -0,0 +1,82 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"chatter/internal/domain"
+
+	"go.uber.org/zap"
+)
+
+var ErrInvalidTicket = errors.New("invalid ticket")
+
+type TicketRepository interface {
+	CreateTicket(ctx context.Context, ticketHash string, ticket *domain.WSTicket, ttl time.Duration) error
+	ConsumeTicket(ctx context.Context, ticketHash string) (*domain.WSTicket, bool)
+}
+
+type TicketService struct {
+	ticketStore TicketRepository
+	ticketTTL   time.Duration
+	logger      *zap.Logger
+}
+
+func NewTicketService(ticketStore TicketRepository, ticketTTL time.Duration, logger *zap.Logger) *TicketService {
+	return &TicketService{
+		ticketStore: ticketStore,
+		ticketTTL:   ticketTTL,
+		logger:      logger,
+	}
+}
+
+// IssueTicket выдаёт одноразовый билет на вход пользователя в комнату.
+// В хранилище лежит только хэш билета, как и у refresh-токенов.
+func (s *TicketService) IssueTicket(ctx context.Context, userID uint64, username, roomID string) (string, time.Time, error) {
+	return s.issue(ctx, &domain.WSTicket{
+		RoomID:   roomID,
+		Username: username,
+		UserID:   userID,
+	})
+}
+
+// IssueGuestTicket выдаёт билет гостю: так гостевой токен тоже не попадает в URL.
+func (s *TicketService) IssueGuestTicket(ctx context.Context, guest *domain.Guest, guestToken, roomID string) (string, time.Time, error) {
+	return s.issue(ctx, &domain.WSTicket{
+		RoomID:     roomID,
+		Username:   guest.DisplayName,
+		UserID:     guest.ID,
+		Guest:      true,
+		GuestToken: guestToken,
+	})
+}
+
+func (s *TicketService) issue(ctx context.Context, record *domain.WSTicket) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate ticket: %w", err)
+	}
+	ticket := hex.EncodeToString(buf)
+
+	record.CreatedAt = time.Now().UTC()
+	if err := s.ticketStore.CreateTicket(ctx, hashToken(ticket), record, s.ticketTTL); err != nil {
+		s.logger.Error("Failed to issue ws ticket", zap.Uint64("userID", record.UserID), zap.String("roomID", record.RoomID), zap.Error(err))
+		return "", time.Time{}, fmt.Errorf("failed to issue ticket: %w", err)
+	}
+
+	return ticket, record.CreatedAt.Add(s.ticketTTL), nil
+}
+
+// RedeemTicket погашает билет. Билет от другой комнаты тоже сгорает.
+func (s *TicketService) RedeemTicket(ctx context.Context, ticket, roomID string) (*domain.WSTicket, error) {
+	record, ok := s.ticketStore.ConsumeTicket(ctx, hashToken(ticket))
+	if !ok || record.RoomID != roomID || record.UserID == 0 {
+		return nil, ErrInvalidTicket
+	}
+
+	return record, nil
+}
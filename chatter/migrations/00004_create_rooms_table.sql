@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS rooms (
+    id TEXT PRIMARY KEY,
+    owner_id BIGINT NOT NULL,
+    title TEXT NOT NULL DEFAULT '',
+    settings JSONB NOT NULL DEFAULT '{}'::jsonb,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT fk_rooms_owner_id FOREIGN KEY (owner_id) REFERENCES users (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rooms_owner_id ON rooms (owner_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS rooms;
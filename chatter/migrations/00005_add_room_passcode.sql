@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE rooms ADD COLUMN passcode_hash BYTEA DEFAULT NULL;
+
+-- +goose Down
+ALTER TABLE rooms DROP COLUMN passcode_hash;
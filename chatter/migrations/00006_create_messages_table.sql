@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS messages (
+    id BIGSERIAL PRIMARY KEY,
+    room_id TEXT NOT NULL,
+    user_id BIGINT NOT NULL DEFAULT 0,
+    display_name TEXT NOT NULL DEFAULT '',
+    text TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_room_id_id ON messages (room_id, id DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS messages;
@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS moderation_audit (
+    id BIGSERIAL PRIMARY KEY,
+    room_id TEXT NOT NULL,
+    actor_id BIGINT NOT NULL,
+    action TEXT NOT NULL,
+    target_id BIGINT NOT NULL DEFAULT 0,
+    target_fingerprint TEXT NOT NULL DEFAULT '',
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_moderation_audit_room_id ON moderation_audit (room_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS moderation_audit;
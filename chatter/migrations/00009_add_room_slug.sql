@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE rooms ADD COLUMN slug TEXT DEFAULT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_rooms_slug ON rooms (slug);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_rooms_slug;
+ALTER TABLE rooms DROP COLUMN slug;
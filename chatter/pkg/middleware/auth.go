@@ -11,10 +11,12 @@ type contextKey string
 const (
 	userKey   contextKey = "authUser"
 	userIDKey contextKey = "authUserID"
+	roleKey   contextKey = "authRole"
 )
 
+// TokenParser возвращает имя, id и роль пользователя из access-токена.
 type TokenParser interface {
-	ParseAccessToken(token string) (string, uint64, error)
+	ParseAccessClaims(token string) (string, uint64, string, error)
 }
 
 func UserFromContext(ctx context.Context) (string, bool) {
@@ -27,21 +29,19 @@ func UserIDFromContext(ctx context.Context) (uint64, bool) {
 	return value, ok
 }
 
+func RoleFromContext(ctx context.Context) (string, bool) {
+	value, ok := ctx.Value(roleKey).(string)
+	return value, ok
+}
+
 func RequireAuth(parser TokenParser, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "missing authorization", http.StatusUnauthorized)
-			return
-		}
-
-		parts := strings.Fields(authHeader)
-		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
-			http.Error(w, "invalid authorization", http.StatusUnauthorized)
+		token, ok := bearerToken(w, r)
+		if !ok {
 			return
 		}
 
-		username, userID, err := parser.ParseAccessToken(parts[1])
+		username, userID, role, err := parser.ParseAccessClaims(token)
 		if err != nil || username == "" || userID == 0 {
 			http.Error(w, "invalid token", http.StatusUnauthorized)
 			return
@@ -49,6 +49,36 @@ func RequireAuth(parser TokenParser, next http.Handler) http.Handler {
 
 		ctx := context.WithValue(r.Context(), userKey, username)
 		ctx = context.WithValue(ctx, userIDKey, userID)
+		ctx = context.WithValue(ctx, roleKey, role)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// RequireRole пропускает только пользователей с нужной ролью в access-токене.
+// Токен уже разобран в RequireAuth, роль берём из контекста.
+func RequireRole(parser TokenParser, role string, next http.Handler) http.Handler {
+	return RequireAuth(parser, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if actual, ok := RoleFromContext(r.Context()); !ok || actual != role {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}))
+}
+
+func bearerToken(w http.ResponseWriter, r *http.Request) (string, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "missing authorization", http.StatusUnauthorized)
+		return "", false
+	}
+
+	parts := strings.Fields(authHeader)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		http.Error(w, "invalid authorization", http.StatusUnauthorized)
+		return "", false
+	}
+
+	return parts[1], true
+}
@@ -1,35 +1,83 @@
 package middleware
 
 import (
-	"expvar"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 var (
-	httpRequestsTotal     = expvar.NewMap("http_requests_total")
-	httpRequestDurationMs = expvar.NewMap("http_request_duration_ms_total")
-	httpResponseSizeBytes = expvar.NewMap("http_response_size_bytes_total")
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "chatter",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request duration by method, route and status. For websocket upgrades (status 101) this is the socket lifetime.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	httpResponseSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "chatter",
+		Subsystem: "http",
+		Name:      "response_size_bytes",
+		Help:      "HTTP response size by method, route and status.",
+		Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+	}, []string{"method", "route", "status"})
+
+	httpRequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "chatter",
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "HTTP requests currently being served.",
+	})
 )
 
 func WithMetrics(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpRequestsInFlight.Inc()
+		defer httpRequestsInFlight.Dec()
+
 		start := time.Now()
 		recorder := newResponseRecorder(w)
 		next.ServeHTTP(recorder, r)
 
-		path := normalizePath(r.URL.Path)
+		// r.Pattern заполняет ServeMux, поэтому шаблон маршрута доступен после обработки
+		method := methodLabel(r.Method)
+		route := routeLabel(r)
 		status := strconv.Itoa(recorder.status)
 
-		labelKey := formatLabels(r.Method, path, status)
-		httpRequestsTotal.Add(labelKey, 1)
-		httpRequestDurationMs.Add(labelKey, time.Since(start).Milliseconds())
-		httpResponseSizeBytes.Add(labelKey, int64(recorder.bytes))
+		httpRequestDuration.WithLabelValues(method, route, status).Observe(time.Since(start).Seconds())
+		httpResponseSize.WithLabelValues(method, route, status).Observe(float64(recorder.bytes))
 	})
 }
 
+// routeLabel возвращает шаблон маршрута без метода, например /rooms/{id}:
+// сырые пути с id комнат раздули бы число серий.
+func routeLabel(r *http.Request) string {
+	if r.Pattern == "" {
+		return "unmatched"
+	}
+
+	if _, route, ok := strings.Cut(r.Pattern, " "); ok {
+		return route
+	}
+
+	return r.Pattern
+}
+
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+
+	return "OTHER"
+}
+
 func normalizePath(path string) string {
 	if strings.HasPrefix(path, "/ws/") && path != "/ws/" {
 		return "/ws/:room"
@@ -37,7 +85,3 @@ func normalizePath(path string) string {
 
 	return path
 }
-
-func formatLabels(method, path, status string) string {
-	return method + "|" + path + "|" + status
-}
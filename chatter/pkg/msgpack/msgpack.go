@@ -0,0 +1,345 @@
+// Package msgpack converts JSON documents to MessagePack and back.
+//
+// Signaling works with JSON internally; binary clients get the same messages
+// transcoded, so only the subset of MessagePack that maps onto JSON is supported:
+// nil, booleans, numbers, strings, binary, arrays and maps with string keys.
+package msgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// maxDepth ограничивает вложенность, чтобы чужой документ не раскрутил рекурсию.
+const maxDepth = 64
+
+var (
+	ErrTruncated   = errors.New("msgpack: unexpected end of data")
+	ErrTooDeep     = errors.New("msgpack: document is nested too deeply")
+	ErrTrailing    = errors.New("msgpack: trailing data after value")
+	ErrUnsupported = errors.New("msgpack: unsupported type")
+)
+
+// FromJSON перекодирует JSON-документ в MessagePack.
+func FromJSON(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, ErrTrailing
+	}
+
+	return appendValue(make([]byte, 0, len(data)), value)
+}
+
+// ToJSON перекодирует MessagePack-документ в JSON.
+func ToJSON(data []byte) ([]byte, error) {
+	d := decoder{data: data}
+
+	value, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, ErrTrailing
+	}
+
+	return json.Marshal(value)
+}
+
+func appendValue(buf []byte, value any) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, 0xc0), nil
+	case bool:
+		if v {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case json.Number:
+		return appendNumber(buf, v)
+	case string:
+		return appendString(buf, v), nil
+	case []any:
+		buf = appendLength(buf, len(v), 0x90, 0xdc, 0xdd)
+		for _, item := range v {
+			var err error
+			if buf, err = appendValue(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		buf = appendLength(buf, len(v), 0x80, 0xde, 0xdf)
+		for _, key := range keys {
+			buf = appendString(buf, key)
+			var err error
+			if buf, err = appendValue(buf, v[key]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupported, value)
+	}
+}
+
+func appendNumber(buf []byte, number json.Number) ([]byte, error) {
+	if i, err := strconv.ParseInt(string(number), 10, 64); err == nil {
+		return appendInt(buf, i), nil
+	}
+	if u, err := strconv.ParseUint(string(number), 10, 64); err == nil {
+		return appendUint(buf, u), nil
+	}
+
+	f, err := number.Float64()
+	if err != nil {
+		return nil, err
+	}
+
+	buf = append(buf, 0xcb)
+	return binary.BigEndian.AppendUint64(buf, math.Float64bits(f)), nil
+}
+
+func appendInt(buf []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return appendUint(buf, uint64(i))
+	case i >= -32:
+		return append(buf, byte(int8(i)))
+	case i >= math.MinInt8:
+		return append(buf, 0xd0, byte(int8(i)))
+	case i >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(int16(i)))
+	case i >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(int32(i)))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(i))
+	}
+}
+
+func appendUint(buf []byte, u uint64) []byte {
+	switch {
+	case u <= 0x7f:
+		return append(buf, byte(u))
+	case u <= math.MaxUint8:
+		return append(buf, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xcd), uint16(u))
+	case u <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xce), uint32(u))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, 0xcf), u)
+	}
+}
+
+func appendString(buf []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xda), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xdb), uint32(n))
+	}
+
+	return append(buf, s...)
+}
+
+// appendLength пишет заголовок массива или словаря: fix-форму, 16- или 32-битную длину.
+func appendLength(buf []byte, n int, fix, code16, code32 byte) []byte {
+	switch {
+	case n < 16:
+		return append(buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, code16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(buf, code32), uint32(n))
+	}
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, ErrTruncated
+	}
+
+	chunk := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return chunk, nil
+}
+
+func (d *decoder) uint(size int) (uint64, error) {
+	chunk, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+
+	switch size {
+	case 1:
+		return uint64(chunk[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(chunk)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(chunk)), nil
+	default:
+		return binary.BigEndian.Uint64(chunk), nil
+	}
+}
+
+func (d *decoder) value(depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, ErrTooDeep
+	}
+
+	head, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+
+	code := head[0]
+	switch {
+	case code <= 0x7f:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code&0xf0 == 0x80:
+		return d.mapValue(int(code&0x0f), depth)
+	case code&0xf0 == 0x90:
+		return d.array(int(code&0x0f), depth)
+	case code&0xe0 == 0xa0:
+		return d.string(int(code & 0x1f))
+	}
+
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uint(1 << (code - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		chunk, err := d.next(int(n))
+		if err != nil {
+			return nil, err
+		}
+		return bytes.Clone(chunk), nil
+	case 0xca:
+		bits, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(bits))), err
+	case 0xcb:
+		bits, err := d.uint(8)
+		return math.Float64frombits(bits), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return d.uint(1 << (code - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (code - 0xd0)
+		u, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		// расширяем знак из size байт до int64
+		shift := 64 - 8*size
+		return int64(u<<shift) >> shift, nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (code - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.string(int(n))
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (code - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.array(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (code - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapValue(int(n), depth)
+	default:
+		return nil, fmt.Errorf("%w: 0x%02x", ErrUnsupported, code)
+	}
+}
+
+func (d *decoder) string(n int) (string, error) {
+	chunk, err := d.next(n)
+	if err != nil {
+		return "", err
+	}
+
+	return string(chunk), nil
+}
+
+func (d *decoder) array(n int, depth int) (any, error) {
+	// каждый элемент занимает хотя бы байт: длина больше остатка - битые данные
+	if n > len(d.data)-d.pos {
+		return nil, ErrTruncated
+	}
+
+	items := make([]any, 0, n)
+	for range n {
+		item, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func (d *decoder) mapValue(n int, depth int) (any, error) {
+	if n > (len(d.data)-d.pos)/2 {
+		return nil, ErrTruncated
+	}
+
+	entries := make(map[string]any, n)
+	for range n {
+		key, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: map key %T", ErrUnsupported, key)
+		}
+
+		value, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		entries[name] = value
+	}
+
+	return entries, nil
+}
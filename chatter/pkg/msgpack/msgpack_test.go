@@ -0,0 +1,156 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{"null", `null`},
+		{"bools", `[true,false]`},
+		{"small ints", `[0,1,127,128,255,256,65535,65536,-1,-32,-33,-128,-129,-32768,-32769]`},
+		{"large ints", `[4294967296,-2147483649,9223372036854775807,-9223372036854775808]`},
+		{"floats", `[1.5,-0.25,1e300]`},
+		{"strings", `["","short","` + strings.Repeat("a", 31) + `","` + strings.Repeat("b", 300) + `","` + strings.Repeat("c", 70000) + `"]`},
+		{"unicode", `"привет, 🌍"`},
+		{"long array", `[` + strings.Repeat(`1,`, 20) + `1]`},
+		{"long map", `{"a":1,"b":2,"c":3,"d":4,"e":5,"f":6,"g":7,"h":8,"i":9,"j":10,"k":11,"l":12,"m":13,"n":14,"o":15,"p":16}`},
+		{"nested", `{"type":"webrtc","from":{"clientId":"abc","userId":7},"candidates":[{"sdpMid":"0"},null],"empty":{}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packed, err := FromJSON([]byte(tt.json))
+			if err != nil {
+				t.Fatalf("FromJSON: %v", err)
+			}
+
+			got, err := ToJSON(packed)
+			if err != nil {
+				t.Fatalf("ToJSON: %v", err)
+			}
+
+			var want, have any
+			if err := json.Unmarshal([]byte(tt.json), &want); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(got, &have); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(want, have) {
+				t.Errorf("round trip changed document:\n got %.200s\nwant %.200s", got, tt.json)
+			}
+		})
+	}
+}
+
+func TestUint64Max(t *testing.T) {
+	packed, err := FromJSON([]byte(`18446744073709551615`))
+	if err != nil {
+		t.Fatalf("FromJSON: %v", err)
+	}
+
+	want := []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	if !bytes.Equal(packed, want) {
+		t.Fatalf("FromJSON = %x, want %x", packed, want)
+	}
+
+	got, err := ToJSON(packed)
+	if err != nil {
+		t.Fatalf("ToJSON: %v", err)
+	}
+	if string(got) != "18446744073709551615" {
+		t.Errorf("ToJSON = %s", got)
+	}
+}
+
+func TestNaN(t *testing.T) {
+	// NaN есть в MessagePack, но не в JSON: перекодировать его нельзя
+	nan := []byte{0xcb, 0x7f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
+	if _, err := ToJSON(nan); err == nil {
+		t.Error("ToJSON(NaN) succeeded, want error")
+	}
+
+	if _, err := FromJSON([]byte(`NaN`)); err == nil {
+		t.Error("FromJSON(NaN) succeeded, want error")
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrTruncated},
+		{"uint16 without payload", []byte{0xcd, 0x01}, ErrTruncated},
+		{"float64 without payload", []byte{0xcb, 0x00}, ErrTruncated},
+		{"fixstr longer than data", []byte{0xa5, 'a', 'b'}, ErrTruncated},
+		{"str8 longer than data", []byte{0xd9, 0x10, 'a'}, ErrTruncated},
+		{"str32 length missing", []byte{0xdb, 0x00, 0x00}, ErrTruncated},
+		{"str32 huge length", []byte{0xdb, 0xff, 0xff, 0xff, 0xff, 'a'}, ErrTruncated},
+		{"bin8 longer than data", []byte{0xc4, 0x04, 0x00}, ErrTruncated},
+		{"array32 huge length", []byte{0xdd, 0xff, 0xff, 0xff, 0xff, 0xc0}, ErrTruncated},
+		{"map32 huge length", []byte{0xdf, 0xff, 0xff, 0xff, 0xff, 0xa1, 'a', 0xc0}, ErrTruncated},
+		{"fixarray missing items", []byte{0x93, 0x01, 0x02}, ErrTruncated},
+		{"fixmap missing value", []byte{0x81, 0xa1, 'a'}, ErrTruncated},
+		{"int key", []byte{0x81, 0x01, 0x02}, ErrUnsupported},
+		{"nil key", []byte{0x81, 0xc0, 0x02}, ErrUnsupported},
+		{"array key", []byte{0x81, 0x90, 0x02}, ErrUnsupported},
+		{"extension type", []byte{0xd4, 0x01, 0x00}, ErrUnsupported},
+		{"trailing data", []byte{0xc0, 0xc0}, ErrTrailing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ToJSON(tt.data); !errors.Is(err, tt.want) {
+				t.Errorf("ToJSON(%x) error = %v, want %v", tt.data, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncatedPrefixes(t *testing.T) {
+	packed, err := FromJSON([]byte(`{"list":[1,-200,70000,1.5,"text"],"nested":{"ok":true}}`))
+	if err != nil {
+		t.Fatalf("FromJSON: %v", err)
+	}
+
+	for n := range len(packed) {
+		if _, err := ToJSON(packed[:n]); !errors.Is(err, ErrTruncated) {
+			t.Errorf("ToJSON of %d/%d bytes: error = %v, want %v", n, len(packed), err, ErrTruncated)
+		}
+	}
+}
+
+func TestDepth(t *testing.T) {
+	nested := func(depth int) []byte {
+		return append(bytes.Repeat([]byte{0x91}, depth), 0xc0)
+	}
+
+	if _, err := ToJSON(nested(maxDepth)); err != nil {
+		t.Errorf("ToJSON at depth %d: %v", maxDepth, err)
+	}
+	if _, err := ToJSON(nested(maxDepth + 1)); !errors.Is(err, ErrTooDeep) {
+		t.Errorf("ToJSON at depth %d: error = %v, want %v", maxDepth+1, err, ErrTooDeep)
+	}
+
+	maps := append(bytes.Repeat([]byte{0x81, 0xa1, 'k'}, maxDepth+1), 0xc0)
+	if _, err := ToJSON(maps); !errors.Is(err, ErrTooDeep) {
+		t.Errorf("ToJSON of nested maps: error = %v, want %v", err, ErrTooDeep)
+	}
+}
+
+func TestFromJSONTrailing(t *testing.T) {
+	if _, err := FromJSON([]byte(`{"a":1} {"b":2}`)); !errors.Is(err, ErrTrailing) {
+		t.Errorf("FromJSON error = %v, want %v", err, ErrTrailing)
+	}
+}
@@ -25,6 +25,13 @@ const ICE_SERVERS = [
 const AUTH_TOKEN_KEY = "authToken";
 const AUTH_USER_KEY = "authUser";
 const DISPLAY_NAME_KEY = "displayName";
+// Signed guest identity issued by the server; keeps the same participant id across reconnects.
+const GUEST_TOKEN_KEY = "guestToken";
+const RECONNECT_DELAY_MS = 1000;
+// Signaling protocol version this client speaks; see web/protocol.schema.json.
+const PROTOCOL_VERSION = 1;
+// The browser client speaks JSON; native clients may pick the MessagePack subprotocol instead.
+const WS_SUBPROTOCOL = `chatter.v${PROTOCOL_VERSION}.json`;
 
 function randomId() {
   return Math.random().toString(36).slice(2, 10);
@@ -41,10 +48,12 @@ function getDeviceId() {
   return deviceId;
 }
 
-function buildWsUrl(serverUrl, roomId, token) {
+function buildWsUrl(serverUrl, roomId) {
   const wsBase = serverUrl.replace(/^http/, "ws");
+  const params = new URLSearchParams({ protocol: String(PROTOCOL_VERSION) });
+  const query = params.toString();
   const url = `${wsBase}/ws/${roomId}`;
-  return token ? `${url}?token=${token}` : url;
+  return query ? `${url}?${query}` : url;
 }
 
 function readAuth() {
@@ -101,6 +110,81 @@ async function tryRefreshToken() {
   return null;
 }
 
+// Guests trade their stored guest token for a ticket the same way, so they keep
+// their id across reconnects without putting the token in the URL.
+async function fetchGuestTicket(apiBase, roomId, access) {
+  const guestToken = localStorage.getItem(GUEST_TOKEN_KEY) || "";
+  try {
+    const response = await fetch(`${apiBase}/guest-ticket`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ roomId, guestToken, ...ticketAccess(access) }),
+    });
+    if (!response.ok) {
+      return "";
+    }
+    const data = await response.json();
+    return data.ticket || "";
+  } catch (err) {
+    return "";
+  }
+}
+
+// The room passcode or invite is checked when the ticket is issued, so neither
+// ends up in a URL.
+function ticketAccess(access = {}) {
+  const body = {};
+  if (access.passcode) {
+    body.passcode = access.passcode;
+  }
+  if (access.invite) {
+    body.invite = access.invite;
+  }
+  return body;
+}
+
+// Exchanges the access token for a one-time ticket, so the token itself never
+// appears in the WebSocket URL. Without a session the ticket is a guest one.
+async function fetchWsTicket(wsUrl, access) {
+  const url = new URL(wsUrl);
+  const apiBase = url.origin.replace(/^ws/, "http");
+  const roomId = decodeURIComponent(url.pathname.replace(/^\/ws\//, ""));
+
+  const { token } = readAuth();
+  if (!token) {
+    return fetchGuestTicket(apiBase, roomId, access);
+  }
+
+  const request = (accessToken) =>
+    fetch(`${apiBase}/ws-ticket`, {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+        Authorization: `Bearer ${accessToken}`,
+      },
+      body: JSON.stringify({ roomId, ...ticketAccess(access) }),
+      credentials: "include",
+    });
+
+  try {
+    let response = await request(token);
+    if (response.status === 401) {
+      const refreshed = await tryRefreshToken();
+      if (!refreshed) {
+        return fetchGuestTicket(apiBase, roomId, access);
+      }
+      response = await request(refreshed.token);
+    }
+    if (!response.ok) {
+      return "";
+    }
+    const data = await response.json();
+    return data.ticket || "";
+  } catch (err) {
+    return "";
+  }
+}
+
 function VideoTile({ stream, muted, label }) {
   const ref = useRef(null);
 
@@ -364,8 +448,11 @@ function DashboardPage() {
     const response = await fetch(`${serverUrl}/rooms`, {
       method: "POST",
       headers: {
+        "Content-Type": "application/json",
         Authorization: authToken ? `Bearer ${authToken}` : "",
       },
+      // A pronounceable name like brave-otter-742 is easier to share than the hex id.
+      body: JSON.stringify({ randomSlug: true }),
     });
     if (!response.ok) {
       setAuthError("Create room requires login");
@@ -373,7 +460,7 @@ function DashboardPage() {
       return;
     }
     const data = await response.json();
-    setRoomId(data.roomId);
+    setRoomId(data.slug || data.roomId);
     setWsUrl(data.wsUrl);
   }
 
@@ -494,11 +581,11 @@ function DashboardPage() {
         <div className="row">
           <button onClick={createRoom}>Create room</button>
           <div className="field grow">
-            <label>Room ID</label>
+            <label>Room ID or name</label>
             <input
               value={roomId}
               onChange={(event) => setRoomId(event.target.value)}
-              placeholder="room id"
+              placeholder="room id or name"
             />
           </div>
           <button onClick={joinById} disabled={!roomId}>
@@ -558,10 +645,16 @@ function RoomPage() {
   const [micEnabled, setMicEnabled] = useState(false);
   const [camEnabled, setCamEnabled] = useState(false);
   const [peerStatus, setPeerStatus] = useState([]);
+  const [lobbyStatus, setLobbyStatus] = useState("");
+  const [lobbyRequests, setLobbyRequests] = useState([]);
   const socketRef = useRef(null);
   const peersRef = useRef(new Map());
   const pendingOffersRef = useRef(new Map());
+  // Trace context of the current offer/answer exchange with each peer.
+  const tracesRef = useRef(new Map());
+  // clientId addresses this connection in signaling; userId is shared by all of the user's devices.
   const [clientId, setClientId] = useState("");
+  const [userId, setUserId] = useState(0);
   const [localName, setLocalName] = useState("");
   const [activeTab, setActiveTab] = useState("video");
   const navigate = useNavigate();
@@ -570,26 +663,25 @@ function RoomPage() {
   const serverUrl = search.get("server") || API_BASE;
   const customWsUrl = search.get("ws");
   const nameParam = search.get("name");
-  // If we have a custom WS URL, use it. Otherwise build one with roomId and auth token.
-  // We need to read the token from localStorage (readAuth) because authToken state might not be set yet on initial render.
-  const { token } = readAuth();
-  let wsUrl = customWsUrl || buildWsUrl(serverUrl, roomId || "", token);
-  if (customWsUrl && token) {
-    // Append token to custom URL if not present
-    if (!wsUrl.includes("token=")) {
-      wsUrl += wsUrl.includes("?") ? `&token=${token}` : `?token=${token}`;
-    }
-  }
+  // If we have a custom WS URL, use it. Otherwise build one with roomId.
+  // Authentication is added per connection attempt as a one-time ticket.
+  const wsUrl = customWsUrl || buildWsUrl(serverUrl, roomId || "");
+  const passcode = search.get("passcode");
+  const invite = search.get("invite");
 
-  function upsertParticipant(id) {
+  function upsertParticipant(id, device) {
     setParticipants((prev) => {
       const exists = prev.some((p) => p.id === id);
       if (exists) {
-        return prev.map((p) =>
-          p.id === id ? { ...p, lastSeen: Date.now() } : p
-        );
+        return prev.map((p) => {
+          if (p.id !== id) {
+            return p;
+          }
+          const devices = device && !p.devices.includes(device) ? [...p.devices, device] : p.devices;
+          return { ...p, devices, lastSeen: Date.now() };
+        });
       }
-      return [...prev, { id, lastSeen: Date.now() }];
+      return [...prev, { id, devices: device ? [device] : [], lastSeen: Date.now() }];
     });
   }
 
@@ -597,6 +689,16 @@ function RoomPage() {
     setParticipants((prev) => prev.filter((p) => p.id !== id));
   }
 
+  function removeDevice(id, device) {
+    setParticipants((prev) =>
+      prev.map((p) => (p.id === id ? { ...p, devices: p.devices.filter((d) => d !== device) } : p))
+    );
+  }
+
+  function ownerOf(device) {
+    return participants.find((p) => p.devices.includes(device))?.id;
+  }
+
   function setDisplayNameFor(id, name) {
     if (!id || !name) {
       return;
@@ -605,7 +707,7 @@ function RoomPage() {
   }
 
   function displayNameFor(id) {
-    if (id === clientId && localName) {
+    if (id === userId && localName) {
       return localName;
     }
     return displayNames[id] || "Anonymous";
@@ -663,9 +765,9 @@ function RoomPage() {
         sendSignal({
           type: "webrtc",
           action: "ice",
-          from: clientId,
           to: remoteId,
           candidate: event.candidate,
+          traceparent: tracesRef.current.get(remoteId),
         });
       }
     };
@@ -701,7 +803,6 @@ function RoomPage() {
     sendSignal({
       type: "webrtc",
       action: "offer",
-      from: clientId,
       to: remoteId,
       sdp: offer,
     });
@@ -720,9 +821,9 @@ function RoomPage() {
         sendSignal({
           type: "webrtc",
           action: "answer",
-          from: clientId,
           to: fromId,
           sdp: answer,
+          traceparent: tracesRef.current.get(fromId),
         });
       })
       .catch(() => {});
@@ -804,9 +905,12 @@ function RoomPage() {
     if (!wsUrl) {
       return;
     }
-    setStatus("connecting");
-    const socket = new WebSocket(wsUrl);
-    socketRef.current = socket;
+    let disposed = false;
+    let resumeToken = "";
+    let retryTimer = null;
+    let selfUserId = 0;
+    let reconnectDelay = RECONNECT_DELAY_MS;
+    let serverRestarting = false;
 
     const sendProfile = () => {
       if (!localName) {
@@ -818,20 +922,21 @@ function RoomPage() {
       });
     };
 
-    socket.onopen = () => {
-      setStatus("connected");
-      sendProfile();
-    };
-    socket.onclose = () => setStatus("disconnected");
-    socket.onerror = () => setStatus("error");
-    socket.onmessage = (event) => {
+    const handleMessage = (event) => {
       try {
         const payload = JSON.parse(event.data);
         if (payload?.type === "welcome" && payload.clientId) {
+          resumeToken = payload.resumeToken || "";
+          selfUserId = payload.userId;
+          if (payload.guestToken) {
+            localStorage.setItem(GUEST_TOKEN_KEY, payload.guestToken);
+          }
+          setLobbyStatus("");
           setClientId(payload.clientId);
-          upsertParticipant(payload.clientId);
+          setUserId(payload.userId);
+          upsertParticipant(payload.userId, payload.clientId);
           if (localName) {
-            setDisplayNameFor(payload.clientId, localName);
+            setDisplayNameFor(payload.userId, localName);
             sendProfile();
           }
           return;
@@ -840,6 +945,7 @@ function RoomPage() {
           setParticipants(
             payload.participants.map((entry) => ({
               id: entry.id,
+              devices: (entry.devices || []).map((device) => device.clientId),
               lastSeen: Date.now(),
             }))
           );
@@ -855,50 +961,139 @@ function RoomPage() {
           sendProfile();
           return;
         }
-        if (payload?.type === "profile" && payload.clientId && payload.displayName) {
-          setDisplayNameFor(payload.clientId, payload.displayName);
+        if (payload?.type === "history" && Array.isArray(payload.messages)) {
+          // History arrives after joining, so it may repeat messages already received live.
+          setMessages((prev) => {
+            const seen = new Set(prev.filter((msg) => msg.id).map((msg) => msg.id));
+            return [...payload.messages.filter((msg) => !seen.has(msg.id)), ...prev];
+          });
+          return;
+        }
+        if (payload?.type === "profile" && payload.from?.userId && payload.displayName) {
+          setDisplayNameFor(payload.from.userId, payload.displayName);
           return;
         }
-        if (payload?.type === "webrtc" && payload.from) {
+        // The server stamps relayed messages with the sender's identity in `from`.
+        if (payload?.type === "webrtc" && payload.from?.clientId) {
+          const fromId = payload.from.clientId;
           if (payload.to && clientId && payload.to !== clientId) {
             return;
           }
+          if ((payload.action === "offer" || payload.action === "answer") && payload.traceparent) {
+            tracesRef.current.set(fromId, payload.traceparent);
+          }
           if (payload.action === "offer" && payload.sdp) {
             if (!localStream) {
-              pendingOffersRef.current.set(payload.from, payload.sdp);
+              pendingOffersRef.current.set(fromId, payload.sdp);
               return;
             }
-            handleOffer(payload.from, payload.sdp);
+            handleOffer(fromId, payload.sdp);
             return;
           }
           if (payload.action === "answer" && payload.sdp) {
-            const pc = peersRef.current.get(payload.from);
+            const pc = peersRef.current.get(fromId);
             if (pc) {
               pc.setRemoteDescription(payload.sdp).catch(() => {});
             }
             return;
           }
-          if (payload.action === "ice" && payload.candidate) {
-            const pc = peersRef.current.get(payload.from);
+          if (payload.action === "ice" && (payload.candidate || payload.candidates)) {
+            const pc = peersRef.current.get(fromId);
             if (pc) {
-              pc.addIceCandidate(payload.candidate).catch(() => {});
+              // The server may coalesce several pending candidates into one message.
+              const candidates = payload.candidates || [payload.candidate];
+              candidates.forEach((candidate) => {
+                pc.addIceCandidate(candidate).catch(() => {});
+              });
             }
             return;
           }
         }
+        if (payload?.type === "lobby" && payload.status) {
+          setLobbyStatus(payload.status);
+          if (payload.status === "denied") {
+            resumeToken = "";
+          }
+          return;
+        }
+        if (payload?.type === "lobby_request" && payload.requestId) {
+          setLobbyRequests((prev) => {
+            const rest = prev.filter((entry) => entry.requestId !== payload.requestId);
+            return payload.status === "pending" ? [...rest, payload] : rest;
+          });
+          return;
+        }
+        if (payload?.type === "server_shutdown") {
+          // The server is restarting: come back after the suggested delay so that
+          // clients do not all reconnect at the same moment.
+          reconnectDelay = payload.reconnectAfterMs || RECONNECT_DELAY_MS;
+          serverRestarting = true;
+          return;
+        }
+        if (payload?.type === "system") {
+          if (payload.event === "room_closed" || payload.event === "disconnected") {
+            resumeToken = "";
+          }
+          const text = {
+            announcement: payload.text,
+            room_closed: "The room was closed by an administrator",
+            disconnected: "You were disconnected by an administrator",
+          }[payload.event];
+          setMessages((prev) => [
+            ...prev,
+            {
+              type: "system",
+              ts: payload.ts,
+              text: payload.reason ? `${text}: ${payload.reason}` : text,
+            },
+          ]);
+          return;
+        }
+        if (payload?.type === "moderation") {
+          if (payload.target === selfUserId) {
+            if (payload.action === "mute") {
+              setMicEnabled(false);
+            } else {
+              // Kicked or banned: the server will close the socket, do not try to resume.
+              resumeToken = "";
+            }
+          }
+          const who = payload.target === selfUserId ? "You were" : "A participant was";
+          const verb = { kick: "removed", ban: "banned", mute: "asked to mute" }[payload.action];
+          setMessages((prev) => [
+            ...prev,
+            {
+              type: "moderation",
+              ts: payload.ts,
+              text: `${who} ${verb}${payload.reason ? `: ${payload.reason}` : ""}`,
+            },
+          ]);
+          return;
+        }
         if (payload?.type === "presence") {
-          if (payload.action === "join") {
-            upsertParticipant(payload.clientId);
-            sendProfile();
+          if (payload.displayName) {
+            setDisplayNameFor(payload.userId, payload.displayName);
+          }
+          // "user" presence tracks people, "device" presence tracks their connections.
+          if (payload.scope === "device") {
+            if (payload.action === "join") {
+              upsertParticipant(payload.userId, payload.clientId);
+              sendProfile();
+            } else if (payload.action === "leave") {
+              removeDevice(payload.userId, payload.clientId);
+            }
+          } else if (payload.action === "join") {
+            upsertParticipant(payload.userId);
           } else if (payload.action === "leave") {
-            removeParticipant(payload.clientId);
+            removeParticipant(payload.userId);
           }
+          return;
         }
-        if (payload?.displayName && payload?.clientId) {
-          setDisplayNameFor(payload.clientId, payload.displayName);
+        if (payload?.from?.displayName && payload.from.userId) {
+          setDisplayNameFor(payload.from.userId, payload.from.displayName);
         }
-        if (payload?.clientId) {
-          upsertParticipant(payload.clientId);
+        if (payload?.from?.userId) {
+          upsertParticipant(payload.from.userId, payload.from.clientId);
         }
         setMessages((prev) => [...prev, payload]);
       } catch {
@@ -906,10 +1101,48 @@ function RoomPage() {
       }
     };
 
+    const connect = async () => {
+      setStatus("connecting");
+      const url = new URL(wsUrl);
+      if (resumeToken) {
+        url.searchParams.set("resume", resumeToken);
+      }
+      // Tickets are single-use, so every attempt, including reconnects, needs a fresh one.
+      const ticket = await fetchWsTicket(wsUrl, { passcode, invite });
+      if (disposed) {
+        return;
+      }
+      if (ticket) {
+        url.searchParams.set("ticket", ticket);
+      }
+      const socket = new WebSocket(url.toString(), [WS_SUBPROTOCOL]);
+      socketRef.current = socket;
+
+      socket.onopen = () => {
+        setStatus("connected");
+        sendProfile();
+      };
+      socket.onclose = (event) => {
+        setStatus("disconnected");
+        // The server keeps our slot for a short grace window after an abnormal drop.
+        if (!disposed && (resumeToken || serverRestarting) && event.code !== 1000) {
+          retryTimer = setTimeout(connect, reconnectDelay);
+          reconnectDelay = RECONNECT_DELAY_MS;
+          serverRestarting = false;
+        }
+      };
+      socket.onerror = () => setStatus("error");
+      socket.onmessage = handleMessage;
+    };
+
+    connect();
+
     return () => {
-      socket.close();
+      disposed = true;
+      clearTimeout(retryTimer);
+      socketRef.current?.close(1000);
     };
-  }, [wsUrl]);
+  }, [wsUrl, passcode, invite]);
 
   useEffect(() => {
     if (!localStream || status !== "connected") {
@@ -921,7 +1154,7 @@ function RoomPage() {
     }
 
     const ids = participants
-      .map((p) => p.id)
+      .flatMap((p) => p.devices)
       .filter((id) => id && id !== clientId && clientId);
     ids.forEach((id) => {
       if (!peersRef.current.has(id) && shouldInitiate(id)) {
@@ -933,11 +1166,17 @@ function RoomPage() {
       if (!ids.includes(id)) {
         pc.close();
         peersRef.current.delete(id);
+        tracesRef.current.delete(id);
         removeRemoteStream(id);
       }
     }
   }, [participants, clientId, localStream, status]);
 
+  function answerLobby(requestId, action) {
+    sendSignal({ type: "lobby", action, requestId });
+    setLobbyRequests((prev) => prev.filter((entry) => entry.requestId !== requestId));
+  }
+
   function sendMessage() {
     if (!socketRef.current || socketRef.current.readyState !== WebSocket.OPEN) {
       return;
@@ -948,14 +1187,10 @@ function RoomPage() {
 
     const payload = {
       type: "chat",
-      clientId,
-      displayName: localName || displayNameFor(clientId),
       text: text.trim(),
       ts: new Date().toISOString(),
     };
-    upsertParticipant(clientId);
     socketRef.current.send(JSON.stringify(payload));
-    setMessages((prev) => [...prev, payload]);
     setText("");
   }
 
@@ -967,6 +1202,7 @@ function RoomPage() {
       pc.close();
     }
     peersRef.current.clear();
+    tracesRef.current.clear();
     if (localStream) {
       localStream.getTracks().forEach((track) => track.stop());
     }
@@ -1009,6 +1245,25 @@ function RoomPage() {
           <div className={`status status-${status}`}>{status}</div>
         </div>
 
+        {lobbyRequests.length > 0 && (
+          <div className="participants">
+            <div className="participants-title">Waiting ({lobbyRequests.length})</div>
+            <div className="participants-list">
+              {lobbyRequests.map((entry) => (
+                <div key={entry.requestId} className="participant lobby-request">
+                  <span className="participant-name">{entry.displayName || "Guest"}</span>
+                  <button className="btn-lobby" onClick={() => answerLobby(entry.requestId, "admit")}>
+                    Admit
+                  </button>
+                  <button className="btn-lobby" onClick={() => answerLobby(entry.requestId, "deny")}>
+                    Deny
+                  </button>
+                </div>
+              ))}
+            </div>
+          </div>
+        )}
+
         <div className="participants">
           <div className="participants-title">Participants ({participants.length})</div>
           <div className="participants-list">
@@ -1018,7 +1273,10 @@ function RoomPage() {
             {participants.map((participant) => (
               <div key={participant.id} className="participant">
                 <span className="participant-name">{displayNameFor(participant.id)}</span>
-                {participant.id === clientId && <span className="badge-me"> (You)</span>}
+                {participant.id === userId && <span className="badge-me"> (You)</span>}
+                {participant.devices.length > 1 && (
+                  <span className="badge-devices"> · {participant.devices.length} devices</span>
+                )}
               </div>
             ))}
           </div>
@@ -1055,12 +1313,18 @@ function RoomPage() {
         </header>
 
         {mediaError && <div className="error-banner">{mediaError}</div>}
+        {lobbyStatus === "waiting" && (
+          <div className="error-banner">Waiting for the host to let you in...</div>
+        )}
+        {lobbyStatus === "denied" && (
+          <div className="error-banner">The host did not let you in.</div>
+        )}
         <div className="video-grid">
           <div className="video-wrapper local">
             <VideoTile
               stream={localStream}
               muted
-              label={`${localName || displayNameFor(clientId)} (You)`}
+              label={`${localName || displayNameFor(userId)} (You)`}
             />
           </div>
           {remoteStreams.map((remote) => (
@@ -1070,8 +1334,8 @@ function RoomPage() {
                 stream={remote.stream}
                 label={
                   getPeerStatus(remote.id)
-                    ? `${displayNameFor(remote.id)} · ${getPeerStatus(remote.id)}`
-                    : displayNameFor(remote.id)
+                    ? `${displayNameFor(ownerOf(remote.id))} · ${getPeerStatus(remote.id)}`
+                    : displayNameFor(ownerOf(remote.id))
                 }
               />
             </div>
@@ -1089,14 +1353,14 @@ function RoomPage() {
             <div
               key={`${msg.ts || "raw"}-${idx}`}
               className={`message ${
-                msg.clientId === clientId ? "message-self" : "message-other"
+                msg.from?.userId === userId ? "message-self" : "message-other"
               }`}
             >
               {msg.type === "chat" ? (
                 <>
                   <div className="message-header">
                     <span className="sender-name">
-                      {msg.displayName || displayNameFor(msg.clientId)}
+                      {msg.from?.displayName || displayNameFor(msg.from?.userId)}
                     </span>
                   </div>
                   <div className="message-content">{msg.text}</div>